@@ -0,0 +1,58 @@
+package db
+
+import "database/sql"
+
+// UpsertCICheck records the latest state ("pending", "success" or "failure")
+// of a single CI check on a commit. name identifies the check within the
+// commit, e.g. a check run name or a commit status context.
+func UpsertCICheck(database *sql.DB, owner, repo, headSHA, name, state string) error {
+	_, err := database.Exec(
+		`INSERT INTO ci_checks (github_owner, github_repo, head_sha, name, state)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (github_owner, github_repo, head_sha, name) DO UPDATE
+		 SET state = excluded.state, updated_at = CURRENT_TIMESTAMP`,
+		owner, repo, headSHA, name, state,
+	)
+	return err
+}
+
+// GetCIStatus rolls every check recorded for a commit up into one state:
+// "failure" if any check failed, "pending" if any is still running,
+// "success" otherwise. Returns an empty string if no checks were reported.
+func GetCIStatus(database *sql.DB, owner, repo, headSHA string) (string, error) {
+	var failed, pending, total int
+	err := database.QueryRow(
+		`SELECT COALESCE(SUM(state = 'failure'), 0), COALESCE(SUM(state = 'pending'), 0), COUNT(*)
+		 FROM ci_checks WHERE github_owner = ? AND github_repo = ? AND head_sha = ?`,
+		owner, repo, headSHA,
+	).Scan(&failed, &pending, &total)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case total == 0:
+		return "", nil
+	case failed > 0:
+		return "failure", nil
+	case pending > 0:
+		return "pending", nil
+	default:
+		return "success", nil
+	}
+}
+
+// DeleteCIChecksUnlessTracked forgets the checks recorded for a commit,
+// unless a PR on an active tracker is still at that commit.
+func DeleteCIChecksUnlessTracked(database *sql.DB, owner, repo, headSHA string) error {
+	_, err := database.Exec(
+		`DELETE FROM ci_checks
+		 WHERE github_owner = ? COLLATE NOCASE AND github_repo = ? COLLATE NOCASE AND head_sha = ?
+		   AND NOT EXISTS (
+		       SELECT 1 FROM pull_requests p JOIN trackers t ON t.id = p.tracker_id
+		       WHERE t.status = 'active' AND p.head_sha = ?
+		         AND p.github_owner = ? COLLATE NOCASE AND p.github_repo = ? COLLATE NOCASE)`,
+		owner, repo, headSHA, headSHA, owner, repo,
+	)
+	return err
+}
@@ -0,0 +1,56 @@
+package db
+
+import "testing"
+
+func TestDeleteCIChecksUnlessTracked(t *testing.T) {
+	database := newTestDB(t)
+
+	trackerID, err := CreateTracker(database, "C1", "U1")
+	if err != nil {
+		t.Fatalf("CreateTracker: %v", err)
+	}
+	prID, err := CreatePullRequest(database, trackerID, "octo", "app", 1,
+		"https://github.com/octo/app/pull/1", ApprovalRequirement{Required: 1, Source: "default"})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if err := UpdatePullRequestHeadSHA(database, prID, "new"); err != nil {
+		t.Fatalf("UpdatePullRequestHeadSHA: %v", err)
+	}
+	for _, sha := range []string{"old", "new"} {
+		if err := UpsertCICheck(database, "Octo", "App", sha, "build", "success"); err != nil {
+			t.Fatalf("UpsertCICheck: %v", err)
+		}
+	}
+
+	ciStatus := func(sha string) string {
+		t.Helper()
+		status, err := GetCIStatus(database, "Octo", "App", sha)
+		if err != nil {
+			t.Fatalf("GetCIStatus: %v", err)
+		}
+		return status
+	}
+
+	for _, sha := range []string{"old", "new"} {
+		if err := DeleteCIChecksUnlessTracked(database, "octo", "app", sha); err != nil {
+			t.Fatalf("DeleteCIChecksUnlessTracked: %v", err)
+		}
+	}
+	if got := ciStatus("old"); got != "" {
+		t.Errorf("old commit's CI = %q, want it forgotten", got)
+	}
+	if got := ciStatus("new"); got != "success" {
+		t.Errorf("tracked commit's CI = %q, want it kept", got)
+	}
+
+	if err := UntrackTracker(database, trackerID); err != nil {
+		t.Fatalf("UntrackTracker: %v", err)
+	}
+	if err := DeleteCIChecksUnlessTracked(database, "octo", "app", "new"); err != nil {
+		t.Fatalf("DeleteCIChecksUnlessTracked: %v", err)
+	}
+	if got := ciStatus("new"); got != "" {
+		t.Errorf("untracked commit's CI = %q, want it forgotten", got)
+	}
+}
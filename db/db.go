@@ -13,7 +13,12 @@ import (
 )
 
 func Connect(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
+	// Pragmas in the DSN apply to every connection in the pool.
+	// busy_timeout makes a writer wait for the lock instead of failing with
+	// SQLITE_BUSY while another goroutine writes, and immediate transactions
+	// take the write lock up front so they can't deadlock upgrading to it.
+	dsn := dbPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open db: %w", err)
 	}
@@ -22,11 +27,6 @@ func Connect(dbPath string) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to ping db: %w", err)
 	}
 
-	// enable WAL mode as per project spec
-	if _, err := db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
-	}
-
 	if err := runMigrations(db); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
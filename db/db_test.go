@@ -0,0 +1,33 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDB opens a migrated database in a temporary directory. Migrations
+// are read relative to the repo root, so the test runs from there.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir("..")
+	database, err := Connect(filepath.Join(dir, "revue.db"))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+	return database
+}
+
+// countJobs returns how many jobs of a kind are queued.
+func countJobs(t *testing.T, database *sql.DB, kind string) int {
+	t.Helper()
+
+	var n int
+	if err := database.QueryRow("SELECT COUNT(*) FROM jobs WHERE kind = ?", kind).Scan(&n); err != nil {
+		t.Fatalf("count jobs: %v", err)
+	}
+	return n
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// EnqueueGitHubDelivery records a webhook delivery by its X-GitHub-Delivery
+// GUID and queues the job that processes it, in one transaction. Returns
+// false without queuing anything if the delivery was already recorded,
+// i.e. GitHub is redelivering it. The job is queued in partitionKey's
+// partition (see EnqueueJob).
+func EnqueueGitHubDelivery(database *sql.DB, deliveryID, kind, payload, partitionKey string, receivedAt time.Time) (bool, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(
+		"INSERT INTO github_deliveries (delivery_id, received_at) VALUES (?, ?) ON CONFLICT (delivery_id) DO NOTHING",
+		deliveryID, receivedAt.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	if err := enqueueJob(tx, kind, payload, "", partitionKey, receivedAt); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeleteGitHubDeliveriesBefore forgets deliveries received before cutoff.
+// Returns the number of deliveries deleted.
+func DeleteGitHubDeliveriesBefore(database *sql.DB, cutoff time.Time) (int64, error) {
+	result, err := database.Exec(
+		"DELETE FROM github_deliveries WHERE received_at < ?",
+		cutoff.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEnqueueGitHubDeliverySkipsRedeliveries(t *testing.T) {
+	database := newTestDB(t)
+	now := time.Now()
+
+	queued, err := EnqueueGitHubDelivery(database, "guid-1", "github_webhook", `{}`, "", now)
+	if err != nil || !queued {
+		t.Fatalf("first delivery: queued = %v, err = %v; want true, nil", queued, err)
+	}
+
+	queued, err = EnqueueGitHubDelivery(database, "guid-1", "github_webhook", `{}`, "", now.Add(time.Minute))
+	if err != nil || queued {
+		t.Fatalf("redelivery: queued = %v, err = %v; want false, nil", queued, err)
+	}
+
+	if n := countJobs(t, database, "github_webhook"); n != 1 {
+		t.Errorf("queued %d jobs, want 1", n)
+	}
+}
+
+func TestDeleteGitHubDeliveriesBefore(t *testing.T) {
+	database := newTestDB(t)
+	now := time.Now()
+
+	for id, age := range map[string]time.Duration{
+		"old":    8 * 24 * time.Hour,
+		"recent": time.Hour,
+	} {
+		if _, err := EnqueueGitHubDelivery(database, id, "github_webhook", `{}`, "", now.Add(-age)); err != nil {
+			t.Fatalf("enqueue %s: %v", id, err)
+		}
+	}
+
+	deleted, err := DeleteGitHubDeliveriesBefore(database, now.Add(-7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteGitHubDeliveriesBefore: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d deliveries, want 1", deleted)
+	}
+
+	// The expired GUID is forgotten, so it can be queued again; the recent
+	// one is still remembered
+	for id, want := range map[string]bool{"old": true, "recent": false} {
+		queued, err := EnqueueGitHubDelivery(database, id, "github_webhook", `{}`, "", now)
+		if err != nil {
+			t.Fatalf("re-enqueue %s: %v", id, err)
+		}
+		if queued != want {
+			t.Errorf("re-enqueue %s: queued = %v, want %v", id, queued, want)
+		}
+	}
+}
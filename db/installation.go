@@ -0,0 +1,83 @@
+package db
+
+import "database/sql"
+
+// UpsertInstallation records a GitHub App installation on an account.
+func UpsertInstallation(database *sql.DB, installationID int64, accountLogin string) error {
+	_, err := database.Exec(
+		`INSERT INTO github_installations (installation_id, account_login) VALUES (?, ?)
+		 ON CONFLICT (installation_id) DO UPDATE SET account_login = excluded.account_login`,
+		installationID, accountLogin,
+	)
+	return err
+}
+
+// SetInstallationSuspended marks an installation as suspended (or not).
+// Repos of a suspended installation are treated as inaccessible.
+func SetInstallationSuspended(database *sql.DB, installationID int64, suspended bool) error {
+	_, err := database.Exec(
+		"UPDATE github_installations SET suspended = ? WHERE installation_id = ?",
+		suspended, installationID,
+	)
+	return err
+}
+
+// DeleteInstallation forgets an installation and every repo it granted.
+func DeleteInstallation(database *sql.DB, installationID int64) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec("DELETE FROM github_installation_repos WHERE installation_id = ?", installationID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM github_installations WHERE installation_id = ?", installationID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddInstallationRepo records that an installation can access a repo.
+func AddInstallationRepo(database *sql.DB, installationID int64, owner, repo string) error {
+	_, err := database.Exec(
+		`INSERT INTO github_installation_repos (installation_id, github_owner, github_repo) VALUES (?, ?, ?)
+		 ON CONFLICT (github_owner, github_repo) DO UPDATE SET installation_id = excluded.installation_id`,
+		installationID, owner, repo,
+	)
+	return err
+}
+
+// RemoveInstallationRepo forgets that an installation can access a repo.
+func RemoveInstallationRepo(database *sql.DB, installationID int64, owner, repo string) error {
+	_, err := database.Exec(
+		"DELETE FROM github_installation_repos WHERE installation_id = ? AND github_owner = ? AND github_repo = ?",
+		installationID, owner, repo,
+	)
+	return err
+}
+
+// FindInstallationForRepo returns the ID of the active installation that
+// can access a repo. Returns sql.ErrNoRows if none is known.
+func FindInstallationForRepo(database *sql.DB, owner, repo string) (int64, error) {
+	var installationID int64
+	err := database.QueryRow(
+		`SELECT r.installation_id FROM github_installation_repos r
+		 JOIN github_installations i ON i.installation_id = r.installation_id
+		 WHERE r.github_owner = ? AND r.github_repo = ? AND i.suspended = 0`,
+		owner, repo,
+	).Scan(&installationID)
+	return installationID, err
+}
+
+// FindAnyInstallation returns the ID of an active installation, for calls
+// that don't concern a particular repo. Returns sql.ErrNoRows if none is
+// known.
+func FindAnyInstallation(database *sql.DB) (int64, error) {
+	var installationID int64
+	err := database.QueryRow(
+		"SELECT installation_id FROM github_installations WHERE suspended = 0 ORDER BY installation_id LIMIT 1",
+	).Scan(&installationID)
+	return installationID, err
+}
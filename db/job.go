@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Job represents a claimed row from the jobs table.
+type Job struct {
+	ID       int64
+	Kind     string
+	Payload  string
+	Attempts int
+}
+
+// EnqueueJob adds a job that becomes runnable at runAt. If dedupKey is
+// non-empty and a pending job with the same key already exists, no new
+// job is added. If partitionKey is non-empty, the job doesn't run until
+// every job queued before it with the same key has finished.
+func EnqueueJob(database *sql.DB, kind, payload, dedupKey, partitionKey string, runAt time.Time) error {
+	return enqueueJob(database, kind, payload, dedupKey, partitionKey, runAt)
+}
+
+// enqueueJob is EnqueueJob on either the database or a transaction.
+func enqueueJob(ex execer, kind, payload, dedupKey, partitionKey string, runAt time.Time) error {
+	_, err := ex.Exec(
+		`INSERT INTO jobs (kind, payload, dedup_key, partition_key, next_attempt_at)
+		 VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), ?)
+		 ON CONFLICT (dedup_key) WHERE status = 'pending' DO NOTHING`,
+		kind, payload, dedupKey, partitionKey, runAt.UTC(),
+	)
+	return err
+}
+
+// ClaimJob marks the oldest runnable pending job as processing and returns
+// it, counting the attempt. A job waits while an earlier job in its
+// partition is pending (e.g. backing off) or processing. Returns
+// sql.ErrNoRows if no job is due.
+func ClaimJob(database *sql.DB, now time.Time) (*Job, error) {
+	j := &Job{}
+	err := database.QueryRow(
+		`UPDATE jobs SET status = 'processing', attempts = attempts + 1
+		 WHERE id = (SELECT j.id FROM jobs j
+		             WHERE j.status = 'pending' AND j.next_attempt_at <= ?
+		               AND (j.partition_key IS NULL OR NOT EXISTS (
+		                   SELECT 1 FROM jobs p
+		                   WHERE p.partition_key = j.partition_key AND p.id < j.id
+		                     AND p.status IN ('pending', 'processing')))
+		             ORDER BY j.next_attempt_at, j.id LIMIT 1)
+		 RETURNING id, kind, payload, attempts`,
+		now.UTC(),
+	).Scan(&j.ID, &j.Kind, &j.Payload, &j.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// CompleteJob removes a job that ran successfully.
+func CompleteJob(database *sql.DB, jobID int64) error {
+	_, err := database.Exec("DELETE FROM jobs WHERE id = ?", jobID)
+	return err
+}
+
+// RetryJob puts a failed job back in the queue to run again at runAt.
+// If an equivalent job (same dedup key) was queued while this one ran,
+// this one is dropped instead, since the queued job does the same work.
+func RetryJob(database *sql.DB, jobID int64, runAt time.Time, lastError string) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.Exec(
+		`DELETE FROM jobs
+		 WHERE id = ? AND dedup_key IS NOT NULL AND EXISTS (
+		     SELECT 1 FROM jobs o
+		     WHERE o.dedup_key = jobs.dedup_key AND o.status = 'pending' AND o.id != jobs.id)`,
+		jobID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err = tx.Exec(
+			"UPDATE jobs SET status = 'pending', next_attempt_at = ?, last_error = ? WHERE id = ?",
+			runAt.UTC(), lastError, jobID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FailJob gives up on a job, keeping it with its last error for inspection.
+func FailJob(database *sql.DB, jobID int64, lastError string) error {
+	_, err := database.Exec(
+		"UPDATE jobs SET status = 'failed', last_error = ? WHERE id = ?",
+		lastError, jobID,
+	)
+	return err
+}
+
+// ResetProcessingJobs returns jobs that were being processed when the
+// process last stopped to the queue. A job whose dedup key is shared with
+// a pending job, or a later interrupted one, is dropped instead, as that
+// job does the same work. Returns the number of jobs reset or dropped.
+func ResetProcessingJobs(database *sql.DB) (int64, error) {
+	tx, err := database.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	dropped, err := tx.Exec(
+		`DELETE FROM jobs
+		 WHERE status = 'processing' AND dedup_key IS NOT NULL AND EXISTS (
+		     SELECT 1 FROM jobs o
+		     WHERE o.dedup_key = jobs.dedup_key AND o.id != jobs.id
+		       AND (o.status = 'pending' OR (o.status = 'processing' AND o.id > jobs.id)))`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	reset, err := tx.Exec("UPDATE jobs SET status = 'pending' WHERE status = 'processing'")
+	if err != nil {
+		return 0, err
+	}
+
+	nDropped, err := dropped.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	nReset, err := reset.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return nDropped + nReset, tx.Commit()
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+)
+
+// pendingPayloads returns the payloads of pending jobs, in queue order.
+func pendingPayloads(t *testing.T, database *sql.DB) []string {
+	t.Helper()
+
+	rows, err := database.Query("SELECT payload FROM jobs WHERE status = 'pending' ORDER BY id")
+	if err != nil {
+		t.Fatalf("query jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var payloads []string
+	for rows.Next() {
+		var payload string
+		if err := rows.Scan(&payload); err != nil {
+			t.Fatalf("scan job: %v", err)
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// enqueueAndClaim queues a job and claims it straight away.
+func enqueueAndClaim(t *testing.T, database *sql.DB, payload, dedupKey string, now time.Time) *Job {
+	t.Helper()
+
+	if err := EnqueueJob(database, "test", payload, dedupKey, "", now); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	job, err := ClaimJob(database, now)
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if job.Payload != payload {
+		t.Fatalf("claimed %q, want %q", job.Payload, payload)
+	}
+	return job
+}
+
+func TestRetryJobDefersToQueuedDuplicate(t *testing.T) {
+	database := newTestDB(t)
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	lone := enqueueAndClaim(t, database, "lone", "a", now)
+	if err := RetryJob(database, lone.ID, now.Add(time.Minute), "boom"); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+
+	// A duplicate of this one is queued while it runs
+	running := enqueueAndClaim(t, database, "first", "b", now)
+	if err := EnqueueJob(database, "test", "second", "b", "", now); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	if err := RetryJob(database, running.ID, now.Add(time.Minute), "boom"); err != nil {
+		t.Fatalf("RetryJob: %v", err)
+	}
+
+	got := pendingPayloads(t, database)
+	want := []string{"lone", "second"}
+	if !slices.Equal(got, want) {
+		t.Errorf("pending jobs = %q, want %q", got, want)
+	}
+}
+
+func TestResetProcessingJobsMergesDuplicates(t *testing.T) {
+	database := newTestDB(t)
+	now := time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	enqueueAndClaim(t, database, "lone", "a", now)
+	// Two interrupted runs of a job with nothing queued behind them
+	enqueueAndClaim(t, database, "b1", "b", now)
+	enqueueAndClaim(t, database, "b2", "b", now)
+	// Two more, and a third run queued behind them
+	enqueueAndClaim(t, database, "c1", "c", now)
+	enqueueAndClaim(t, database, "c2", "c", now)
+	if err := EnqueueJob(database, "test", "c3", "c", "", now); err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+
+	n, err := ResetProcessingJobs(database)
+	if err != nil {
+		t.Fatalf("ResetProcessingJobs: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("ResetProcessingJobs = %d, want 5", n)
+	}
+
+	got := pendingPayloads(t, database)
+	want := []string{"lone", "b2", "c3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("pending jobs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE channel_reminders DROP COLUMN last_fired_at;
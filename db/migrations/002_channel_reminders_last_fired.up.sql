@@ -0,0 +1 @@
+ALTER TABLE channel_reminders ADD COLUMN last_fired_at DATETIME;
@@ -0,0 +1,9 @@
+CREATE TABLE pull_request_reviews
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    pull_request_id INTEGER  NOT NULL REFERENCES pull_requests (id),
+    github_login    TEXT     NOT NULL,
+    state           TEXT     NOT NULL,
+    submitted_at    DATETIME NOT NULL,
+    UNIQUE (pull_request_id, github_login)
+);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS stale_approval_policies;
+ALTER TABLE pull_request_reviews DROP COLUMN commit_id;
+ALTER TABLE pull_requests DROP COLUMN new_commits_since_approval;
+ALTER TABLE pull_requests DROP COLUMN head_sha;
@@ -0,0 +1,14 @@
+ALTER TABLE pull_requests ADD COLUMN head_sha TEXT NOT NULL DEFAULT '';
+ALTER TABLE pull_requests ADD COLUMN new_commits_since_approval INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pull_request_reviews ADD COLUMN commit_id TEXT NOT NULL DEFAULT '';
+
+-- A row opts either a whole channel (github_owner/github_repo left empty)
+-- or a single repo (slack_channel_id left empty) into resetting approvals
+-- when new commits are pushed.
+CREATE TABLE stale_approval_policies
+(
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    slack_channel_id TEXT NOT NULL DEFAULT '',
+    github_owner     TEXT NOT NULL DEFAULT '',
+    github_repo      TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,11 @@
+CREATE TABLE ci_checks
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    github_owner TEXT     NOT NULL,
+    github_repo  TEXT     NOT NULL,
+    head_sha     TEXT     NOT NULL,
+    name         TEXT     NOT NULL,
+    state        TEXT     NOT NULL,
+    updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (github_owner, github_repo, head_sha, name)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE trackers DROP COLUMN completed_at;
+ALTER TABLE trackers DROP COLUMN created_by_slack_user_id;
@@ -0,0 +1,2 @@
+ALTER TABLE trackers ADD COLUMN created_by_slack_user_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE trackers ADD COLUMN completed_at DATETIME;
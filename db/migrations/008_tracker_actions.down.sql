@@ -0,0 +1,2 @@
+ALTER TABLE reviewers DROP COLUMN claimed_at;
+ALTER TABLE trackers DROP COLUMN snoozed_until;
@@ -0,0 +1,2 @@
+ALTER TABLE trackers ADD COLUMN snoozed_until DATETIME;
+ALTER TABLE reviewers ADD COLUMN claimed_at DATETIME;
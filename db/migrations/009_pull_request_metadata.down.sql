@@ -0,0 +1,5 @@
+ALTER TABLE pull_requests DROP COLUMN deletions;
+ALTER TABLE pull_requests DROP COLUMN additions;
+ALTER TABLE pull_requests DROP COLUMN base_branch;
+ALTER TABLE pull_requests DROP COLUMN author_login;
+ALTER TABLE pull_requests DROP COLUMN title;
@@ -0,0 +1,5 @@
+ALTER TABLE pull_requests ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE pull_requests ADD COLUMN author_login TEXT NOT NULL DEFAULT '';
+ALTER TABLE pull_requests ADD COLUMN base_branch TEXT NOT NULL DEFAULT '';
+ALTER TABLE pull_requests ADD COLUMN additions INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE pull_requests ADD COLUMN deletions INTEGER NOT NULL DEFAULT 0;
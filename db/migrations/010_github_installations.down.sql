@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS github_installation_repos;
+DROP TABLE IF EXISTS github_installations;
@@ -0,0 +1,14 @@
+CREATE TABLE github_installations
+(
+    installation_id INTEGER PRIMARY KEY,
+    account_login   TEXT    NOT NULL,
+    suspended       INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE github_installation_repos
+(
+    installation_id INTEGER NOT NULL REFERENCES github_installations (installation_id),
+    github_owner    TEXT    NOT NULL COLLATE NOCASE,
+    github_repo     TEXT    NOT NULL COLLATE NOCASE,
+    PRIMARY KEY (github_owner, github_repo)
+);
@@ -0,0 +1,22 @@
+CREATE TABLE jobs
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind            TEXT     NOT NULL,
+    payload         TEXT     NOT NULL,
+    dedup_key       TEXT,
+    partition_key   TEXT,
+    status          TEXT     NOT NULL DEFAULT 'pending',
+    attempts        INTEGER  NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error      TEXT     NOT NULL DEFAULT '',
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_jobs_pending ON jobs (status, next_attempt_at);
+
+-- At most one pending job per dedup key, so bursts of events collapse
+CREATE UNIQUE INDEX idx_jobs_pending_dedup ON jobs (dedup_key) WHERE status = 'pending';
+
+-- Jobs sharing a partition key (e.g. webhooks about the same PR) run one
+-- at a time in the order they were queued, retries included
+CREATE INDEX idx_jobs_partition ON jobs (partition_key, id) WHERE partition_key IS NOT NULL;
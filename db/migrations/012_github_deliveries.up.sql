@@ -0,0 +1,7 @@
+CREATE TABLE github_deliveries
+(
+    delivery_id TEXT PRIMARY KEY,
+    received_at DATETIME NOT NULL
+);
+
+CREATE INDEX idx_github_deliveries_received_at ON github_deliveries (received_at);
@@ -0,0 +1,11 @@
+-- source is 'manual' (/revue link) or 'email' (matched automatically).
+-- Manual links take precedence and are never overwritten by email matches.
+CREATE TABLE user_links
+(
+    github_login  TEXT     NOT NULL PRIMARY KEY COLLATE NOCASE,
+    slack_user_id TEXT     NOT NULL,
+    source        TEXT     NOT NULL,
+    created_at    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_user_links_slack_user_id ON user_links (slack_user_id);
@@ -0,0 +1,2 @@
+ALTER TABLE pull_requests DROP COLUMN code_owner_review_required;
+ALTER TABLE pull_requests DROP COLUMN approvals_required_source;
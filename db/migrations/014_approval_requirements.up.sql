@@ -0,0 +1,5 @@
+-- approvals_required_source is 'default', 'manual' (set when tracking) or
+-- 'github' (derived from branch protection / rulesets). Manual values are
+-- never overwritten by derived ones.
+ALTER TABLE pull_requests ADD COLUMN approvals_required_source TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE pull_requests ADD COLUMN code_owner_review_required INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,44 @@
+package db
+
+import "database/sql"
+
+// StaleApprovalPolicyEnabled reports whether new commits on a PR should
+// reset its approvals, either because the tracker's channel or the PR's
+// repo has opted in. Repos match case-insensitively, as on GitHub.
+func StaleApprovalPolicyEnabled(database *sql.DB, channelID, owner, repo string) (bool, error) {
+	var enabled bool
+	err := database.QueryRow(
+		`SELECT EXISTS (
+		     SELECT 1 FROM stale_approval_policies
+		     WHERE (slack_channel_id = ? AND github_owner = '' AND github_repo = '')
+		        OR (slack_channel_id = '' AND github_owner = ? COLLATE NOCASE AND github_repo = ? COLLATE NOCASE)
+		 )`,
+		channelID, owner, repo,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// SetStaleApprovalPolicy opts a channel (owner and repo left empty) or a
+// repo (channelID left empty) in to or out of resetting approvals when new
+// commits are pushed.
+func SetStaleApprovalPolicy(database *sql.DB, channelID, owner, repo string, enabled bool) error {
+	if !enabled {
+		_, err := database.Exec(
+			`DELETE FROM stale_approval_policies
+			 WHERE slack_channel_id = ? AND github_owner = ? COLLATE NOCASE AND github_repo = ? COLLATE NOCASE`,
+			channelID, owner, repo,
+		)
+		return err
+	}
+
+	_, err := database.Exec(
+		`INSERT INTO stale_approval_policies (slack_channel_id, github_owner, github_repo)
+		 SELECT ?, ?, ?
+		 WHERE NOT EXISTS (
+		     SELECT 1 FROM stale_approval_policies
+		     WHERE slack_channel_id = ? AND github_owner = ? COLLATE NOCASE AND github_repo = ? COLLATE NOCASE
+		 )`,
+		channelID, owner, repo, channelID, owner, repo,
+	)
+	return err
+}
@@ -0,0 +1,46 @@
+package db
+
+import "testing"
+
+func TestSetStaleApprovalPolicy(t *testing.T) {
+	database := newTestDB(t)
+
+	enabled := func(channelID, owner, repo string) bool {
+		t.Helper()
+		on, err := StaleApprovalPolicyEnabled(database, channelID, owner, repo)
+		if err != nil {
+			t.Fatalf("StaleApprovalPolicyEnabled: %v", err)
+		}
+		return on
+	}
+
+	// Opting in twice leaves a single row for opting out to remove
+	for range 2 {
+		if err := SetStaleApprovalPolicy(database, "", "Octo", "App", true); err != nil {
+			t.Fatalf("SetStaleApprovalPolicy on: %v", err)
+		}
+	}
+	if !enabled("C1", "octo", "app") {
+		t.Error("repo opt-in not applied to a PR in that repo")
+	}
+	if enabled("C1", "octo", "other") {
+		t.Error("repo opt-in applied to another repo")
+	}
+
+	if err := SetStaleApprovalPolicy(database, "", "octo", "app", false); err != nil {
+		t.Fatalf("SetStaleApprovalPolicy off: %v", err)
+	}
+	if enabled("C1", "octo", "app") {
+		t.Error("repo still opted in after opting out")
+	}
+
+	if err := SetStaleApprovalPolicy(database, "C1", "", "", true); err != nil {
+		t.Fatalf("SetStaleApprovalPolicy channel: %v", err)
+	}
+	if !enabled("C1", "octo", "other") {
+		t.Error("channel opt-in not applied to a PR tracked there")
+	}
+	if enabled("C2", "octo", "other") {
+		t.Error("channel opt-in applied to another channel")
+	}
+}
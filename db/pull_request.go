@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"log"
+	"time"
 )
 
 // PullRequest represents a row from the pull_requests table.
@@ -16,14 +17,94 @@ type PullRequest struct {
 	Status            string
 	ApprovalsRequired int
 	ApprovalsCurrent  int
+	HeadSHA           string
+	// NewCommitsSinceApproval is set when approvals were reset because
+	// new commits were pushed, and cleared by the next approval.
+	NewCommitsSinceApproval bool
+	IsDraft                 bool
+	CreatedAt               time.Time
+	Title                   string
+	AuthorLogin             string
+	BaseBranch              string
+	Additions               int
+	Deletions               int
+	// ApprovalsRequiredSource says where ApprovalsRequired came from:
+	// "default", "manual" or "github".
+	ApprovalsRequiredSource string
+	CodeOwnerReviewRequired bool
+}
+
+// ApprovalRequirement is how many approvals a PR needs before it counts as
+// approved, and where that number came from.
+type ApprovalRequirement struct {
+	Required        int
+	CodeOwnerReview bool   // GitHub also requires a code owner's approval
+	Source          string // "default", "manual" or "github"
+}
+
+// PullRequestMetadata is the subset of a PR's details fetched from GitHub.
+type PullRequestMetadata struct {
+	Title       string
+	AuthorLogin string
+	BaseBranch  string
+	Additions   int
+	Deletions   int
+	HeadSHA     string
+	IsDraft     bool
+}
+
+// pullRequestColumns is the column list scanned by scanPullRequest.
+const pullRequestColumns = `id, tracker_id, github_owner, github_repo, github_pr_number, github_pr_url,
+		        status, approvals_required, approvals_current, head_sha, new_commits_since_approval, is_draft,
+		        created_at, title, author_login, base_branch, additions, deletions,
+		        approvals_required_source, code_owner_review_required`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPullRequest scans a row selected with pullRequestColumns.
+func scanPullRequest(row rowScanner, pr *PullRequest) error {
+	return row.Scan(&pr.ID, &pr.TrackerID, &pr.GithubOwner, &pr.GithubRepo, &pr.GithubPRNumber,
+		&pr.GithubPRURL, &pr.Status, &pr.ApprovalsRequired, &pr.ApprovalsCurrent, &pr.HeadSHA,
+		&pr.NewCommitsSinceApproval, &pr.IsDraft, &pr.CreatedAt, &pr.Title, &pr.AuthorLogin,
+		&pr.BaseBranch, &pr.Additions, &pr.Deletions, &pr.ApprovalsRequiredSource,
+		&pr.CodeOwnerReviewRequired)
+}
+
+// queryPullRequests runs a query selecting pullRequestColumns and scans every row.
+func queryPullRequests(database *sql.DB, query string, args ...any) ([]PullRequest, error) {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}(rows)
+
+	var prs []PullRequest
+	for rows.Next() {
+		var pr PullRequest
+		if err := scanPullRequest(rows, &pr); err != nil {
+			return nil, err
+		}
+		prs = append(prs, pr)
+	}
+	return prs, rows.Err()
 }
 
 // CreatePullRequest inserts a pull request linked to a tracker and returns its ID.
-func CreatePullRequest(database *sql.DB, trackerID int64, owner, repo string, prNumber int, prURL string) (int64, error) {
+func CreatePullRequest(database *sql.DB, trackerID int64, owner, repo string, prNumber int, prURL string, approvals ApprovalRequirement) (int64, error) {
 	result, err := database.Exec(
-		`INSERT INTO pull_requests (tracker_id, github_owner, github_repo, github_pr_number, github_pr_url)
-		 VALUES (?, ?, ?, ?, ?)`,
+		`INSERT INTO pull_requests (tracker_id, github_owner, github_repo, github_pr_number, github_pr_url,
+		                            approvals_required, code_owner_review_required, approvals_required_source)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
 		trackerID, owner, repo, prNumber, prURL,
+		approvals.Required, approvals.CodeOwnerReview, approvals.Source,
 	)
 	if err != nil {
 		return 0, err
@@ -40,19 +121,72 @@ func CreateReviewer(database *sql.DB, pullRequestID int64, slackUserID string) e
 	return err
 }
 
-// FindPullRequest looks up a tracked PR by its GitHub identifiers.
-// Returns sql.ErrNoRows if the PR is not being tracked.
-func FindPullRequest(database *sql.DB, owner, repo string, prNumber int) (*PullRequest, error) {
-	pr := &PullRequest{}
-	err := database.QueryRow(
-		`SELECT id, tracker_id, github_owner, github_repo, github_pr_number, github_pr_url,
-		        status, approvals_required, approvals_current
+// AddReviewer adds a Slack user as a reviewer of a PR unless they already
+// are one. Returns true if they were added.
+func AddReviewer(database *sql.DB, pullRequestID int64, slackUserID string) (bool, error) {
+	result, err := database.Exec(
+		`INSERT INTO reviewers (pull_request_id, slack_user_id)
+		 SELECT ?, ? WHERE NOT EXISTS (
+		     SELECT 1 FROM reviewers WHERE pull_request_id = ? AND slack_user_id = ?
+		 )`,
+		pullRequestID, slackUserID, pullRequestID, slackUserID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ClaimReview marks a Slack user as actively reviewing a PR, adding them
+// as a reviewer first if they weren't one already.
+func ClaimReview(database *sql.DB, pullRequestID int64, slackUserID string) error {
+	result, err := database.Exec(
+		"UPDATE reviewers SET claimed_at = CURRENT_TIMESTAMP WHERE pull_request_id = ? AND slack_user_id = ?",
+		pullRequestID, slackUserID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	_, err = database.Exec(
+		"INSERT INTO reviewers (pull_request_id, slack_user_id, claimed_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+		pullRequestID, slackUserID,
+	)
+	return err
+}
+
+// FindPullRequests looks up every tracked copy of a PR by its GitHub
+// identifiers. The same PR can be on more than one tracker.
+func FindPullRequests(database *sql.DB, owner, repo string, prNumber int) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
 		 FROM pull_requests
 		 WHERE github_owner = ? AND github_repo = ? AND github_pr_number = ?`,
 		owner, repo, prNumber,
-	).Scan(&pr.ID, &pr.TrackerID, &pr.GithubOwner, &pr.GithubRepo, &pr.GithubPRNumber,
-		&pr.GithubPRURL, &pr.Status, &pr.ApprovalsRequired, &pr.ApprovalsCurrent)
-	if err != nil {
+	)
+}
+
+// GetPullRequestByID fetches a single pull request row.
+func GetPullRequestByID(database *sql.DB, prID int64) (*PullRequest, error) {
+	pr := &PullRequest{}
+	row := database.QueryRow(
+		"SELECT "+pullRequestColumns+" FROM pull_requests WHERE id = ?",
+		prID,
+	)
+	if err := scanPullRequest(row, pr); err != nil {
 		return nil, err
 	}
 	return pr, nil
@@ -67,7 +201,7 @@ func UpdatePullRequestApprovals(database *sql.DB, prID int64, approvalsCurrent i
 	return err
 }
 
-// UpdatePullRequestStatus sets the status of a PR (e.g. "open", "approved", "merged", "closed").
+// UpdatePullRequestStatus sets the status of a PR (e.g. "open", "approved", "changes_requested", "merged", "closed").
 func UpdatePullRequestStatus(database *sql.DB, prID int64, status string) error {
 	_, err := database.Exec(
 		"UPDATE pull_requests SET status = ? WHERE id = ?",
@@ -76,43 +210,143 @@ func UpdatePullRequestStatus(database *sql.DB, prID int64, status string) error
 	return err
 }
 
-// GetPullRequestsByTracker fetches all PRs belonging to a tracker.
-func GetPullRequestsByTracker(database *sql.DB, trackerID int64) ([]PullRequest, error) {
-	rows, err := database.Query(
-		`SELECT id, tracker_id, github_owner, github_repo, github_pr_number, github_pr_url,
-		        status, approvals_required, approvals_current
-		 FROM pull_requests WHERE tracker_id = ?`,
-		trackerID,
+// UpdatePullRequestMetadata stores the details fetched from GitHub for a PR.
+func UpdatePullRequestMetadata(database *sql.DB, prID int64, meta PullRequestMetadata) error {
+	_, err := database.Exec(
+		`UPDATE pull_requests
+		 SET title = ?, author_login = ?, base_branch = ?, additions = ?, deletions = ?,
+		     head_sha = ?, is_draft = ?
+		 WHERE id = ?`,
+		meta.Title, meta.AuthorLogin, meta.BaseBranch, meta.Additions, meta.Deletions,
+		meta.HeadSHA, meta.IsDraft, prID,
+	)
+	return err
+}
+
+// UpdateApprovalRequirement sets how many approvals a PR needs. A manually
+// set requirement is only replaced by another manual one. Returns true if
+// the requirement changed.
+func UpdateApprovalRequirement(database *sql.DB, prID int64, approvals ApprovalRequirement) (bool, error) {
+	result, err := database.Exec(
+		`UPDATE pull_requests
+		 SET approvals_required = ?, code_owner_review_required = ?, approvals_required_source = ?
+		 WHERE id = ?
+		   AND (approvals_required_source != 'manual' OR ? = 'manual')
+		   AND (approvals_required != ? OR code_owner_review_required != ? OR approvals_required_source != ?)`,
+		approvals.Required, approvals.CodeOwnerReview, approvals.Source, prID,
+		approvals.Source, approvals.Required, approvals.CodeOwnerReview, approvals.Source,
 	)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer func(rows *sql.Rows) {
-		err := rows.Close()
-		if err != nil {
-			log.Printf("Failed to close rows: %v", err)
-		}
-	}(rows)
 
-	var prs []PullRequest
-	for rows.Next() {
-		var pr PullRequest
-		if err := rows.Scan(&pr.ID, &pr.TrackerID, &pr.GithubOwner, &pr.GithubRepo,
-			&pr.GithubPRNumber, &pr.GithubPRURL, &pr.Status, &pr.ApprovalsRequired,
-			&pr.ApprovalsCurrent); err != nil {
-			return nil, err
-		}
-		prs = append(prs, pr)
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
 	}
-	return prs, rows.Err()
+	return n > 0, nil
 }
 
-// GetReviewersByPR fetches all reviewer Slack user IDs for a pull request.
-func GetReviewersByPR(database *sql.DB, prID int64) ([]string, error) {
-	rows, err := database.Query(
-		"SELECT slack_user_id FROM reviewers WHERE pull_request_id = ?",
-		prID,
+// UpdatePullRequestBaseBranch records the branch a PR targets.
+func UpdatePullRequestBaseBranch(database *sql.DB, prID int64, baseBranch string) error {
+	_, err := database.Exec(
+		"UPDATE pull_requests SET base_branch = ? WHERE id = ?",
+		baseBranch, prID,
+	)
+	return err
+}
+
+// UpdatePullRequestHeadSHA records the latest head commit of a PR.
+func UpdatePullRequestHeadSHA(database *sql.DB, prID int64, headSHA string) error {
+	_, err := database.Exec(
+		"UPDATE pull_requests SET head_sha = ? WHERE id = ?",
+		headSHA, prID,
+	)
+	return err
+}
+
+// SetNewCommitsSinceApproval flags (or clears) that a PR's approvals were
+// reset by newly pushed commits.
+func SetNewCommitsSinceApproval(database *sql.DB, prID int64, flagged bool) error {
+	_, err := database.Exec(
+		"UPDATE pull_requests SET new_commits_since_approval = ? WHERE id = ?",
+		flagged, prID,
+	)
+	return err
+}
+
+// UpdatePullRequestDraft records whether a PR is currently a draft.
+func UpdatePullRequestDraft(database *sql.DB, prID int64, isDraft bool) error {
+	_, err := database.Exec(
+		"UPDATE pull_requests SET is_draft = ? WHERE id = ?",
+		isDraft, prID,
 	)
+	return err
+}
+
+// FindPullRequestsByHeadSHA fetches every tracked PR in a repo whose head
+// commit is headSHA.
+func FindPullRequestsByHeadSHA(database *sql.DB, owner, repo, headSHA string) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
+		 FROM pull_requests
+		 WHERE github_owner = ? AND github_repo = ? AND head_sha = ?`,
+		owner, repo, headSHA,
+	)
+}
+
+// GetPendingReviewsForUser fetches the open, non-draft PRs on active
+// trackers that a Slack user is a reviewer of and hasn't finished
+// reviewing, oldest first.
+func GetPendingReviewsForUser(database *sql.DB, slackUserID string) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
+		 FROM pull_requests
+		 WHERE id IN (SELECT pull_request_id FROM reviewers WHERE slack_user_id = ?)
+		   AND id NOT IN (SELECT rv.pull_request_id FROM pull_request_reviews rv
+		                  JOIN user_links ul ON ul.github_login = rv.github_login
+		                  WHERE ul.slack_user_id = ? AND rv.state IN ('approved', 'changes_requested'))
+		   AND tracker_id IN (SELECT id FROM trackers WHERE status = 'active')
+		   AND status = 'open' AND is_draft = 0
+		 ORDER BY created_at ASC, id ASC`,
+		slackUserID, slackUserID,
+	)
+}
+
+// GetPullRequestsOnActiveTrackers fetches every PR belonging to an active tracker.
+func GetPullRequestsOnActiveTrackers(database *sql.DB) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
+		 FROM pull_requests
+		 WHERE tracker_id IN (SELECT id FROM trackers WHERE status = 'active')
+		 ORDER BY tracker_id, id`,
+	)
+}
+
+// GetTrackedPullRequestsByCreator fetches the PRs on active trackers that
+// a Slack user created.
+func GetTrackedPullRequestsByCreator(database *sql.DB, slackUserID string) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
+		 FROM pull_requests
+		 WHERE tracker_id IN (SELECT id FROM trackers WHERE status = 'active' AND created_by_slack_user_id = ?)
+		 ORDER BY created_at ASC, id ASC`,
+		slackUserID,
+	)
+}
+
+// GetPullRequestsByTracker fetches all PRs belonging to a tracker.
+func GetPullRequestsByTracker(database *sql.DB, trackerID int64) ([]PullRequest, error) {
+	return queryPullRequests(database,
+		`SELECT `+pullRequestColumns+`
+		 FROM pull_requests WHERE tracker_id = ?`,
+		trackerID,
+	)
+}
+
+// queryUserIDs runs a query selecting a single Slack user ID column.
+func queryUserIDs(database *sql.DB, query string, args ...any) ([]string, error) {
+	rows, err := database.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -133,3 +367,14 @@ func GetReviewersByPR(database *sql.DB, prID int64) ([]string, error) {
 	}
 	return userIDs, rows.Err()
 }
+
+// GetReviewersByTracker fetches the distinct reviewer Slack user IDs across
+// every PR in a tracker.
+func GetReviewersByTracker(database *sql.DB, trackerID int64) ([]string, error) {
+	return queryUserIDs(database,
+		`SELECT DISTINCT r.slack_user_id FROM reviewers r
+		 JOIN pull_requests pr ON pr.id = r.pull_request_id
+		 WHERE pr.tracker_id = ?`,
+		trackerID,
+	)
+}
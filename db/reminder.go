@@ -0,0 +1,52 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// ChannelReminder represents a row from the channel_reminders table.
+type ChannelReminder struct {
+	ID              int64
+	SlackChannelID  string
+	IntervalMinutes int
+	LastFiredAt     sql.NullTime
+}
+
+// GetEnabledChannelReminders fetches every channel that has reminders turned on.
+func GetEnabledChannelReminders(database *sql.DB) ([]ChannelReminder, error) {
+	rows, err := database.Query(
+		`SELECT id, slack_channel_id, interval_minutes, last_fired_at
+		 FROM channel_reminders WHERE enabled = 1`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}(rows)
+
+	var reminders []ChannelReminder
+	for rows.Next() {
+		var r ChannelReminder
+		if err := rows.Scan(&r.ID, &r.SlackChannelID, &r.IntervalMinutes, &r.LastFiredAt); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+// UpdateChannelReminderLastFired records when a channel's reminder last ran,
+// so the schedule survives restarts.
+func UpdateChannelReminderLastFired(database *sql.DB, reminderID int64, firedAt time.Time) error {
+	_, err := database.Exec(
+		"UPDATE channel_reminders SET last_fired_at = ? WHERE id = ?",
+		firedAt.UTC(), reminderID,
+	)
+	return err
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// UpsertReview records the latest review state a GitHub user left on a PR,
+// along with the commit the review was made against. Each reviewer has a
+// single row per PR; an older review (e.g. a delayed webhook) never
+// overwrites a newer one. As on GitHub, a "commented" review doesn't
+// replace an approval or change request.
+func UpsertReview(database *sql.DB, prID int64, githubLogin, state, commitID string, submittedAt time.Time) error {
+	_, err := database.Exec(
+		`INSERT INTO pull_request_reviews (pull_request_id, github_login, state, commit_id, submitted_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (pull_request_id, github_login) DO UPDATE
+		 SET state = excluded.state, commit_id = excluded.commit_id, submitted_at = excluded.submitted_at
+		 WHERE excluded.submitted_at >= pull_request_reviews.submitted_at
+		   AND (excluded.state != 'commented' OR pull_request_reviews.state IN ('commented', 'dismissed'))`,
+		prID, githubLogin, state, commitID, submittedAt.UTC(),
+	)
+	return err
+}
+
+// DismissApprovalsBeforeCommit marks every approval on a PR that was made
+// against a commit other than headSHA as dismissed. Returns the number of
+// approvals that were dismissed.
+func DismissApprovalsBeforeCommit(database *sql.DB, prID int64, headSHA string) (int64, error) {
+	result, err := database.Exec(
+		`UPDATE pull_request_reviews SET state = 'dismissed'
+		 WHERE pull_request_id = ? AND state = 'approved' AND commit_id != ?`,
+		prID, headSHA,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// CountReviewsByState returns the number of distinct reviewers whose latest
+// review on a PR is in the given state (e.g. "approved").
+func CountReviewsByState(database *sql.DB, prID int64, state string) (int, error) {
+	var count int
+	err := database.QueryRow(
+		"SELECT COUNT(*) FROM pull_request_reviews WHERE pull_request_id = ? AND state = ?",
+		prID, state,
+	).Scan(&count)
+	return count, err
+}
+
+// GetReviewLoginsByState returns the GitHub logins whose latest review on
+// a PR is in the given state (e.g. "approved").
+func GetReviewLoginsByState(database *sql.DB, prID int64, state string) ([]string, error) {
+	rows, err := database.Query(
+		"SELECT github_login FROM pull_request_reviews WHERE pull_request_id = ? AND state = ? ORDER BY github_login",
+		prID, state,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}(rows)
+
+	var logins []string
+	for rows.Next() {
+		var login string
+		if err := rows.Scan(&login); err != nil {
+			return nil, err
+		}
+		logins = append(logins, login)
+	}
+	return logins, rows.Err()
+}
+
+// ReviewerStatus is where a PR's Slack reviewer is with their review.
+type ReviewerStatus struct {
+	SlackUserID string
+	State       string // latest review state from GitHub, empty if none
+	Claimed     bool   // clicked "I'm reviewing"
+}
+
+// Finished reports whether the reviewer has approved or requested changes,
+// i.e. nothing more is expected of them until the PR changes.
+func (s ReviewerStatus) Finished() bool {
+	return s.State == "approved" || s.State == "changes_requested"
+}
+
+// GetReviewerStatuses fetches the Slack reviewers of a PR, sorted by user
+// ID, with the latest review each left on GitHub through their linked
+// logins.
+func GetReviewerStatuses(database *sql.DB, prID int64) ([]ReviewerStatus, error) {
+	// A Slack user can have several linked logins; the rows for each user
+	// are ordered so their most recent review comes first.
+	rows, err := database.Query(
+		`SELECT r.slack_user_id, COALESCE(rv.state, ''), r.claimed_at IS NOT NULL
+		 FROM reviewers r
+		 LEFT JOIN user_links ul ON ul.slack_user_id = r.slack_user_id
+		 LEFT JOIN pull_request_reviews rv ON rv.pull_request_id = r.pull_request_id
+		                                  AND ul.github_login = rv.github_login
+		 WHERE r.pull_request_id = ?
+		 ORDER BY r.slack_user_id, rv.submitted_at DESC`,
+		prID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}(rows)
+
+	var statuses []ReviewerStatus
+	for rows.Next() {
+		var s ReviewerStatus
+		if err := rows.Scan(&s.SlackUserID, &s.State, &s.Claimed); err != nil {
+			return nil, err
+		}
+		if n := len(statuses); n > 0 && statuses[n-1].SlackUserID == s.SlackUserID {
+			statuses[n-1].Claimed = statuses[n-1].Claimed || s.Claimed
+			continue
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
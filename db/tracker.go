@@ -3,22 +3,60 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"log"
+	"time"
 )
 
 // Tracker represents a row from the trackers table.
 type Tracker struct {
-	ID             int64
-	SlackChannelID string
-	SlackMessageTS string
-	Status         string
+	ID                   int64
+	SlackChannelID       string
+	SlackMessageTS       string
+	Status               string
+	CreatedBySlackUserID string
+	CompletedAt          sql.NullTime
+	SnoozedUntil         sql.NullTime
+}
+
+// trackerColumns is the column list scanned by scanTracker.
+const trackerColumns = `id, slack_channel_id, slack_message_ts, status, created_by_slack_user_id, completed_at, snoozed_until`
+
+// scanTracker scans a row selected with trackerColumns.
+func scanTracker(row rowScanner, t *Tracker) error {
+	return row.Scan(&t.ID, &t.SlackChannelID, &t.SlackMessageTS, &t.Status,
+		&t.CreatedBySlackUserID, &t.CompletedAt, &t.SnoozedUntil)
+}
+
+// queryTrackers runs a query selecting trackerColumns and scans every row.
+func queryTrackers(database *sql.DB, query string, args ...any) ([]Tracker, error) {
+	rows, err := database.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Failed to close rows: %v", err)
+		}
+	}(rows)
+
+	var trackers []Tracker
+	for rows.Next() {
+		var t Tracker
+		if err := scanTracker(rows, &t); err != nil {
+			return nil, err
+		}
+		trackers = append(trackers, t)
+	}
+	return trackers, rows.Err()
 }
 
 // CreateTracker inserts a new tracker row and returns its ID.
 // The slack_message_ts starts empty — we update it after posting to Slack.
-func CreateTracker(database *sql.DB, channelID string) (int64, error) {
+func CreateTracker(database *sql.DB, channelID, createdBySlackUserID string) (int64, error) {
 	result, err := database.Exec(
-		"INSERT INTO trackers (slack_channel_id, slack_message_ts) VALUES (?, ?)",
-		channelID, "",
+		"INSERT INTO trackers (slack_channel_id, slack_message_ts, created_by_slack_user_id) VALUES (?, ?, ?)",
+		channelID, "", createdBySlackUserID,
 	)
 	if err != nil {
 		return 0, err
@@ -39,18 +77,63 @@ func UpdateTrackerMessageTS(database *sql.DB, trackerID int64, messageTS string)
 // GetTrackerByID fetches a single tracker row.
 func GetTrackerByID(database *sql.DB, trackerID int64) (*Tracker, error) {
 	t := &Tracker{}
-	err := database.QueryRow(
-		"SELECT id, slack_channel_id, slack_message_ts, status FROM trackers WHERE id = ?",
+	row := database.QueryRow(
+		"SELECT "+trackerColumns+" FROM trackers WHERE id = ?",
 		trackerID,
-	).Scan(&t.ID, &t.SlackChannelID, &t.SlackMessageTS, &t.Status)
-	if err != nil {
+	)
+	if err := scanTracker(row, t); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
-// CompleteTrackerIfDone checks if all PRs in a tracker are merged or closed.
-// If so, it marks the tracker status as "completed".
+// GetActiveTrackersByChannel fetches all active trackers posted in a channel.
+func GetActiveTrackersByChannel(database *sql.DB, channelID string) ([]Tracker, error) {
+	return queryTrackers(database,
+		`SELECT `+trackerColumns+`
+		 FROM trackers WHERE slack_channel_id = ? AND status = 'active'`,
+		channelID,
+	)
+}
+
+// GetRecentlyCompletedTrackers fetches the most recently completed trackers
+// a Slack user was involved in, either as the creator or as a reviewer.
+func GetRecentlyCompletedTrackers(database *sql.DB, slackUserID string, limit int) ([]Tracker, error) {
+	return queryTrackers(database,
+		`SELECT `+trackerColumns+`
+		 FROM trackers
+		 WHERE status = 'completed'
+		   AND (created_by_slack_user_id = ?
+		        OR id IN (SELECT pr.tracker_id FROM pull_requests pr
+		                  JOIN reviewers r ON r.pull_request_id = pr.id
+		                  WHERE r.slack_user_id = ?))
+		 ORDER BY completed_at DESC
+		 LIMIT ?`,
+		slackUserID, slackUserID, limit,
+	)
+}
+
+// SnoozeTracker suppresses reminders for a tracker until the given time.
+func SnoozeTracker(database *sql.DB, trackerID int64, until time.Time) error {
+	_, err := database.Exec(
+		"UPDATE trackers SET snoozed_until = ? WHERE id = ?",
+		until.UTC(), trackerID,
+	)
+	return err
+}
+
+// UntrackTracker stops tracking a tracker's PRs by marking it "untracked".
+// Untracked trackers are never completed or reactivated.
+func UntrackTracker(database *sql.DB, trackerID int64) error {
+	_, err := database.Exec(
+		"UPDATE trackers SET status = 'untracked' WHERE id = ?",
+		trackerID,
+	)
+	return err
+}
+
+// CompleteTrackerIfDone checks if all PRs in an active tracker are merged
+// or closed. If so, it marks the tracker status as "completed".
 // Returns true if the tracker was completed.
 func CompleteTrackerIfDone(database *sql.DB, trackerID int64) (bool, error) {
 	var openCount int
@@ -66,13 +149,35 @@ func CompleteTrackerIfDone(database *sql.DB, trackerID int64) (bool, error) {
 		return false, nil
 	}
 
-	_, err = database.Exec(
-		"UPDATE trackers SET status = 'completed' WHERE id = ?",
+	result, err := database.Exec(
+		"UPDATE trackers SET status = 'completed', completed_at = CURRENT_TIMESTAMP WHERE id = ? AND status = 'active'",
 		trackerID,
 	)
 	if err != nil {
 		return false, fmt.Errorf("failed to update tracker status: %w", err)
 	}
 
-	return true, nil
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update tracker status: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ReactivateTracker flips a completed tracker back to "active", e.g. when
+// one of its PRs is reopened. Returns true if the tracker was reactivated.
+func ReactivateTracker(database *sql.DB, trackerID int64) (bool, error) {
+	result, err := database.Exec(
+		"UPDATE trackers SET status = 'active', completed_at = NULL WHERE id = ? AND status = 'completed'",
+		trackerID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
 }
@@ -0,0 +1,38 @@
+package db
+
+import "database/sql"
+
+// UserLink represents a row from the user_links table, mapping a GitHub
+// login to the Slack user behind it.
+type UserLink struct {
+	GithubLogin string
+	SlackUserID string
+	Source      string // "manual" or "email"
+}
+
+// GetUserLink looks up the Slack user linked to a GitHub login
+// (case-insensitively). Returns sql.ErrNoRows if the login isn't linked.
+func GetUserLink(database *sql.DB, githubLogin string) (*UserLink, error) {
+	l := &UserLink{}
+	err := database.QueryRow(
+		"SELECT github_login, slack_user_id, source FROM user_links WHERE github_login = ?",
+		githubLogin,
+	).Scan(&l.GithubLogin, &l.SlackUserID, &l.Source)
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// LinkUser links a GitHub login to a Slack user. An automatic ("email")
+// link never replaces a manual one; a manual link replaces anything.
+func LinkUser(database *sql.DB, githubLogin, slackUserID, source string) error {
+	_, err := database.Exec(
+		`INSERT INTO user_links (github_login, slack_user_id, source) VALUES (?, ?, ?)
+		 ON CONFLICT (github_login) DO UPDATE
+		 SET github_login = excluded.github_login, slack_user_id = excluded.slack_user_id, source = excluded.source
+		 WHERE user_links.source != 'manual' OR excluded.source = 'manual'`,
+		githubLogin, slackUserID, source,
+	)
+	return err
+}
@@ -3,6 +3,8 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/dylfrancis/revue/db"
 	"github.com/dylfrancis/revue/server"
@@ -34,12 +36,63 @@ func main() {
 		log.Fatal("SLACK_SIGNING_SECRET is required")
 	}
 
+	// Optional — poll GitHub on this interval (e.g. "2m") instead of
+	// receiving webhooks, for networks GitHub can't reach.
+	var pollInterval time.Duration
+	if raw := os.Getenv("GITHUB_POLL_INTERVAL"); raw != "" {
+		pollInterval, err = time.ParseDuration(raw)
+		if err != nil || pollInterval <= 0 {
+			log.Fatal("GITHUB_POLL_INTERVAL must be a positive duration such as 2m")
+		}
+	}
+
 	githubWebhookSecret := os.Getenv("GITHUB_WEBHOOK_SECRET")
-	if githubWebhookSecret == "" {
-		log.Fatal("GITHUB_WEBHOOK_SECRET is required")
+	if githubWebhookSecret == "" && pollInterval == 0 {
+		log.Fatal("GITHUB_WEBHOOK_SECRET is required unless GITHUB_POLL_INTERVAL is set")
+	}
+
+	// Optional — without it Revue can only read public repos and is subject
+	// to GitHub's much lower anonymous rate limit.
+	githubToken := os.Getenv("GITHUB_TOKEN")
+
+	// Optional — match GitHub reviewers to Slack users by email. Needs the
+	// users:read.email Slack scope.
+	var autoLinkUsers bool
+	if raw := os.Getenv("AUTO_LINK_USERS_BY_EMAIL"); raw != "" {
+		autoLinkUsers, err = strconv.ParseBool(raw)
+		if err != nil {
+			log.Fatal("AUTO_LINK_USERS_BY_EMAIL must be true or false")
+		}
+	}
+
+	cfg := server.Config{
+		Port:                 "8080",
+		SlackBotToken:        slackBotToken,
+		SlackSigningSecret:   slackSigningSecret,
+		GitHubWebhookSecret:  githubWebhookSecret,
+		GitHubToken:          githubToken,
+		AutoLinkUsersByEmail: autoLinkUsers,
+		PollInterval:         pollInterval,
+	}
+
+	// Optional — run as a GitHub App instead of using GITHUB_TOKEN
+	if appID := os.Getenv("GITHUB_APP_ID"); appID != "" {
+		cfg.GitHubAppID, err = strconv.ParseInt(appID, 10, 64)
+		if err != nil {
+			log.Fatal("GITHUB_APP_ID must be a number")
+		}
+
+		keyPath := os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH")
+		if keyPath == "" {
+			log.Fatal("GITHUB_APP_PRIVATE_KEY_PATH is required when GITHUB_APP_ID is set")
+		}
+		cfg.GitHubAppPrivateKey, err = os.ReadFile(keyPath)
+		if err != nil {
+			log.Fatalf("Failed to read GitHub App private key: %v", err)
+		}
 	}
 
-	if err := server.Start("8080", slackBotToken, slackSigningSecret, githubWebhookSecret, database); err != nil {
+	if err := server.Start(cfg, database); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+)
+
+// recentCompletionsLimit caps how many completed trackers the Home tab shows.
+const recentCompletionsLimit = 5
+
+// handleSlackEvent receives Events API callbacks. Requests are already
+// signature-checked by verifySlackRequest, so the legacy verification token
+// is not checked again.
+func handleSlackEvent(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Failed to read event body: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	event, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if err != nil {
+		log.Printf("Failed to parse Slack event: %v", err)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	switch event.Type {
+	case slackevents.URLVerification:
+		// Slack sends this once when the events URL is configured and
+		// expects the challenge echoed back.
+		var challenge slackevents.ChallengeResponse
+		if err := json.Unmarshal(body, &challenge); err != nil {
+			log.Printf("Failed to parse URL verification challenge: %v", err)
+			http.Error(w, "Bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if _, err := w.Write([]byte(challenge.Challenge)); err != nil {
+			log.Printf("Failed to write challenge response: %v", err)
+		}
+		return
+	case slackevents.CallbackEvent:
+		switch e := event.InnerEvent.Data.(type) {
+		case *slackevents.AppHomeOpenedEvent:
+			if e.Tab == "home" {
+				// Slack wants an ack within 3 seconds, and building the view
+				// takes several API calls, so publish in the background.
+				go func(userID string) {
+					if err := publishAppHome(userID); err != nil {
+						log.Printf("Failed to publish App Home for %s: %v", userID, err)
+					}
+				}(e.User)
+			}
+		default:
+			log.Printf("Ignoring Slack event type: %s", event.InnerEvent.Type)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// publishAppHome builds and publishes a user's Home tab: PRs waiting on
+// their review, PRs they're tracking, and their recently completed trackers.
+func publishAppHome(userID string) error {
+	pending, err := db.GetPendingReviewsForUser(database, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get pending reviews: %w", err)
+	}
+
+	tracked, err := db.GetTrackedPullRequestsByCreator(database, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get tracked PRs: %w", err)
+	}
+
+	completed, err := db.GetRecentlyCompletedTrackers(database, userID, recentCompletionsLimit)
+	if err != nil {
+		return fmt.Errorf("failed to get completed trackers: %w", err)
+	}
+
+	var blocks []slack.Block
+	blocks = append(blocks, slack.NewHeaderBlock(slack.NewTextBlockObject("plain_text", "Revue", false, false)))
+
+	var lines []string
+	for _, pr := range pending {
+		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — waiting %s",
+			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+			formatAge(time.Since(pr.CreatedAt))))
+	}
+	blocks = append(blocks, homeSection(
+		fmt.Sprintf("Waiting on your review (%d)", len(pending)),
+		lines, "Nothing is waiting on your review. :tada:")...)
+
+	lines = nil
+	for _, pr := range tracked {
+		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — %s %s",
+			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+			statusEmoji(displayStatus(pr)), statusLabel(displayStatus(pr))))
+	}
+	blocks = append(blocks, homeSection(
+		fmt.Sprintf("PRs you're tracking (%d)", len(tracked)),
+		lines, "You aren't tracking any PRs. Use `/revue track` in a channel to start.")...)
+
+	lines = nil
+	for _, tracker := range completed {
+		line := "• " + trackerLink(tracker)
+		if tracker.CompletedAt.Valid {
+			line += fmt.Sprintf(" — completed %s ago", formatAge(time.Since(tracker.CompletedAt.Time)))
+		}
+		lines = append(lines, line)
+	}
+	blocks = append(blocks, homeSection("Recently completed", lines, "No completed trackers yet.")...)
+
+	view := slack.HomeTabViewRequest{
+		Type:   slack.VTHomeTab,
+		Blocks: slack.Blocks{BlockSet: blocks},
+	}
+	if _, err := slackClient.PublishView(userID, view, ""); err != nil {
+		return fmt.Errorf("failed to publish view: %w", err)
+	}
+
+	return nil
+}
+
+// homeSection renders a titled list for the Home tab, or emptyText when
+// there are no lines.
+func homeSection(title string, lines []string, emptyText string) []slack.Block {
+	body := emptyText
+	if len(lines) > 0 {
+		body = strings.Join(lines, "\n")
+	}
+
+	return []slack.Block{
+		slack.NewDividerBlock(),
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", "*"+title+"*", false, false), nil, nil),
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", body, false, false), nil, nil),
+	}
+}
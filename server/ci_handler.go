@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// checkSuiteEvents translates check_suite events. Only completed suites are
+// recorded: GitHub creates a suite for every installed app with checks
+// access, and suites that are never run would otherwise stay pending forever.
+func checkSuiteEvents(event *github.CheckSuiteEvent) []prEvent {
+	if event.GetAction() != "completed" {
+		return nil
+	}
+
+	suite := event.GetCheckSuite()
+	name := "suite/" + suite.GetApp().GetSlug()
+	return ciCheckEvents(event.GetRepo(), suite.GetHeadSHA(), name, checkState(suite.GetStatus(), suite.GetConclusion()))
+}
+
+// checkRunEvents translates check_run events for every status change of an
+// individual check.
+func checkRunEvents(event *github.CheckRunEvent) []prEvent {
+	run := event.GetCheckRun()
+	name := fmt.Sprintf("run/%s/%s", run.GetApp().GetSlug(), run.GetName())
+	return ciCheckEvents(event.GetRepo(), run.GetHeadSHA(), name, checkState(run.GetStatus(), run.GetConclusion()))
+}
+
+// commitStatusEvents translates status events from the older commit status API.
+func commitStatusEvents(event *github.StatusEvent) []prEvent {
+	return ciCheckEvents(event.GetRepo(), event.GetSHA(), "status/"+event.GetContext(), commitStatusState(event.GetState()))
+}
+
+// commitStatusState maps a commit status onto "pending", "success" or
+// "failure".
+func commitStatusState(state string) string {
+	switch state {
+	case "success":
+		return "success"
+	case "failure", "error":
+		return "failure"
+	default:
+		return "pending"
+	}
+}
+
+// checkState maps a check's status and conclusion onto "pending",
+// "success" or "failure".
+func checkState(status, conclusion string) string {
+	if status != "completed" {
+		return "pending"
+	}
+
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return "success"
+	default: // failure, cancelled, timed_out, action_required, stale, ...
+		return "failure"
+	}
+}
+
+// ciCheckEvents builds the event for a check's state on a commit. The
+// processor matches it to tracked PRs by head commit.
+func ciCheckEvents(ghRepo *github.Repository, headSHA, name, state string) []prEvent {
+	return []prEvent{{
+		Kind:       eventCICheckUpdated,
+		Owner:      ghRepo.GetOwner().GetLogin(),
+		Repo:       ghRepo.GetName(),
+		HeadSHA:    headSHA,
+		CheckName:  name,
+		CheckState: state,
+	}}
+}
+
+// refreshCIStatus records the check runs and commit statuses GitHub already
+// has for a tracked PR's head commit, under the same names their webhooks
+// use, and refreshes the tracker if any were found.
+func refreshCIStatus(prID int64) error {
+	pr, err := db.GetPullRequestByID(database, prID)
+	if err != nil {
+		return fmt.Errorf("failed to get PR: %w", err)
+	}
+	if pr.HeadSHA == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	defer cancel()
+
+	client, err := githubClientFor(ctx, pr.GithubOwner, pr.GithubRepo)
+	if err != nil {
+		return err
+	}
+
+	checks := make(map[string]string)
+	opts := &github.ListCheckRunsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		result, resp, err := client.Checks.ListCheckRunsForRef(ctx, pr.GithubOwner, pr.GithubRepo, pr.HeadSHA, opts)
+		if err != nil {
+			return fmt.Errorf("failed to list check runs: %w", err)
+		}
+		for _, run := range result.CheckRuns {
+			name := fmt.Sprintf("run/%s/%s", run.GetApp().GetSlug(), run.GetName())
+			checks[name] = checkState(run.GetStatus(), run.GetConclusion())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	combined, _, err := client.Repositories.GetCombinedStatus(ctx, pr.GithubOwner, pr.GithubRepo, pr.HeadSHA, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return fmt.Errorf("failed to get commit statuses: %w", err)
+	}
+	for _, status := range combined.Statuses {
+		checks["status/"+status.GetContext()] = commitStatusState(status.GetState())
+	}
+
+	if len(checks) == 0 {
+		return nil
+	}
+	for name, state := range checks {
+		if err := db.UpsertCICheck(database, pr.GithubOwner, pr.GithubRepo, pr.HeadSHA, name, state); err != nil {
+			return fmt.Errorf("failed to record CI check %s: %w", name, err)
+		}
+	}
+	return enqueueTrackerRefresh(pr.TrackerID)
+}
+
+// forgetCIChecks drops the checks recorded for a PR's old or final head
+// commit once no active tracker needs them, so ci_checks only holds
+// commits that are still being tracked. Failures are only logged.
+func forgetCIChecks(owner, repo, headSHA string) {
+	if headSHA == "" {
+		return
+	}
+	if err := db.DeleteCIChecksUnlessTracked(database, owner, repo, headSHA); err != nil {
+		log.Printf("Failed to delete CI checks for %s/%s@%s: %v", owner, repo, headSHA, err)
+	}
+}
+
+// forgetTrackerCIChecks does forgetCIChecks for every PR in a tracker that
+// has completed or been untracked.
+func forgetTrackerCIChecks(trackerID int64) {
+	prs, err := db.GetPullRequestsByTracker(database, trackerID)
+	if err != nil {
+		log.Printf("Failed to get PRs of tracker %d: %v", trackerID, err)
+		return
+	}
+	for _, pr := range prs {
+		forgetCIChecks(pr.GithubOwner, pr.GithubRepo, pr.HeadSHA)
+	}
+}
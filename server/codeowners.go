@@ -0,0 +1,460 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+	"github.com/slack-go/slack"
+)
+
+// codeownersPaths are where GitHub looks for a CODEOWNERS file, in the
+// order it checks them.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// maxPRFilePages caps how many pages of changed files are fetched for a
+// PR; GitHub itself stops listing files at 3000.
+const maxPRFilePages = 30
+
+const (
+	// codeownersCacheTTL is how long a branch's CODEOWNERS rules and a
+	// team's members are reused before being fetched again.
+	codeownersCacheTTL = 10 * time.Minute
+
+	// prFilesCacheTTL is how long a PR's changed files are kept. They're
+	// cached by head commit, so this only bounds memory.
+	prFilesCacheTTL = time.Hour
+)
+
+// Code owner checks run on every review of a PR that needs one, so what
+// they fetch from GitHub is cached: CODEOWNERS rules by repo and base
+// branch, changed files by PR and head commit, and team members by team.
+var (
+	codeownersCache  = newExpiringCache[[]codeownersRule](codeownersCacheTTL)
+	prFilesCache     = newExpiringCache[[]string](prFilesCacheTTL)
+	teamMembersCache = newExpiringCache[[]string](codeownersCacheTTL)
+)
+
+// expiringCache is a concurrency-safe map whose entries are dropped a
+// fixed time after they're stored.
+type expiringCache[V any] struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]expiringEntry[V]
+}
+
+type expiringEntry[V any] struct {
+	value     V
+	expiresAt time.Time
+}
+
+func newExpiringCache[V any](ttl time.Duration) *expiringCache[V] {
+	return &expiringCache[V]{ttl: ttl, entries: make(map[string]expiringEntry[V])}
+}
+
+// get returns the value stored for key, unless it has expired.
+func (c *expiringCache[V]) get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		var zero V
+		return zero, false
+	}
+	return entry.value, true
+}
+
+// set stores value for key, first dropping any entries that have expired.
+func (c *expiringCache[V]) set(key string, value V) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = expiringEntry[V]{value: value, expiresAt: now.Add(c.ttl)}
+}
+
+// codeownersRule is one line of a CODEOWNERS file. A rule with no owners
+// makes matching files unowned.
+type codeownersRule struct {
+	pattern *regexp.Regexp
+	owners  []string // "@login", "@org/team" or an email address
+}
+
+// parseCodeowners parses a CODEOWNERS file, skipping comments and lines
+// whose pattern can't be understood.
+func parseCodeowners(content string) []codeownersRule {
+	var rules []codeownersRule
+	for _, line := range strings.Split(content, "\n") {
+		fields := strings.Fields(stripCodeownersComment(line))
+		if len(fields) == 0 {
+			continue
+		}
+
+		pattern, err := codeownersPattern(strings.ReplaceAll(fields[0], `\#`, "#"))
+		if err != nil {
+			log.Printf("Skipping CODEOWNERS pattern %q: %v", fields[0], err)
+			continue
+		}
+		rules = append(rules, codeownersRule{pattern: pattern, owners: fields[1:]})
+	}
+	return rules
+}
+
+// stripCodeownersComment cuts a comment off a CODEOWNERS line. A comment
+// starts with a "#" at the start of the line or after whitespace; any
+// other "#", including an escaped "\#", is part of a pattern or owner.
+func stripCodeownersComment(line string) string {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// codeownersPattern compiles a CODEOWNERS pattern, which mostly follows
+// .gitignore rules: a leading or inner "/" anchors it to the repo root,
+// "*" and "?" stay within a path segment, "**" spans segments, and a
+// pattern matching a directory matches everything beneath it. Unlike
+// .gitignore, a wildcard in the last segment only matches at that level,
+// so "docs/*" doesn't cover docs/build-app/troubleshooting.md.
+func codeownersPattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+	lastSegment := pattern[strings.LastIndex(pattern, "/")+1:]
+	wildcardLast := strings.Contains(lastSegment, "*")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			re.WriteString("[^/]*")
+		case pattern[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	switch {
+	case dirOnly:
+		re.WriteString("/.*$")
+	case wildcardLast:
+		re.WriteString("$")
+	default:
+		re.WriteString("(?:/.*)?$")
+	}
+
+	return regexp.Compile(re.String())
+}
+
+// ownersOf returns the owners of a file: those of the last rule matching
+// it, as in GitHub.
+func ownersOf(rules []codeownersRule, path string) []string {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].pattern.MatchString(path) {
+			return rules[i].owners
+		}
+	}
+	return nil
+}
+
+// suggestReviewers returns the Slack users who own files changed by any of
+// prs according to their repos' CODEOWNERS, sorted. Owners that can't be
+// mapped to a Slack user are skipped, as are the PRs' authors.
+func suggestReviewers(ctx context.Context, prs []parsedPR) ([]string, error) {
+	// Owners are resolved once every author is known, each in the context
+	// of the first repo it owns files in
+	ownerRepos := make(map[string]parsedPR)
+	authors := make(map[string]bool)
+	for _, pr := range prs {
+		client, err := githubClientFor(ctx, pr.Owner, pr.Repo)
+		if err != nil {
+			return nil, err
+		}
+
+		ghPR, _, err := client.PullRequests.Get(ctx, pr.Owner, pr.Repo, pr.Number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s/%s#%d: %w", pr.Owner, pr.Repo, pr.Number, err)
+		}
+		authors[strings.ToLower(ghPR.GetUser().GetLogin())] = true
+
+		rules, err := fetchCodeowners(ctx, client, pr.Owner, pr.Repo, ghPR.GetBase().GetRef())
+		if err != nil {
+			return nil, err
+		}
+		if len(rules) == 0 {
+			continue
+		}
+
+		files, err := fetchPRFiles(ctx, client, pr.Owner, pr.Repo, pr.Number, ghPR.GetHead().GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			for _, owner := range ownersOf(rules, file) {
+				if _, ok := ownerRepos[owner]; !ok {
+					ownerRepos[owner] = pr
+				}
+			}
+		}
+	}
+
+	suggested := make(map[string]bool)
+	for owner, pr := range ownerRepos {
+		userIDs, err := slackUsersForOwner(ctx, pr.Owner, pr.Repo, owner, authors)
+		if err != nil {
+			log.Printf("Failed to map code owner %s to Slack: %v", owner, err)
+			continue
+		}
+		for _, uid := range userIDs {
+			suggested[uid] = true
+		}
+	}
+
+	var userIDs []string
+	for uid := range suggested {
+		userIDs = append(userIDs, uid)
+	}
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// fetchCodeowners fetches and parses a repo's CODEOWNERS file as of a
+// branch, reusing recently fetched rules. Returns no rules if the repo
+// doesn't have one.
+func fetchCodeowners(ctx context.Context, client *github.Client, owner, repo, ref string) ([]codeownersRule, error) {
+	key := strings.ToLower(owner+"/"+repo) + "@" + ref
+	if rules, ok := codeownersCache.get(key); ok {
+		return rules, nil
+	}
+
+	rules, err := fetchCodeownersFile(ctx, client, owner, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	codeownersCache.set(key, rules)
+	return rules, nil
+}
+
+// fetchCodeownersFile is fetchCodeowners without the cache.
+func fetchCodeownersFile(ctx context.Context, client *github.Client, owner, repo, ref string) ([]codeownersRule, error) {
+	for _, path := range codeownersPaths {
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s: %w", path, err)
+		}
+		if file == nil {
+			continue // a directory of that name
+		}
+
+		content, err := file.GetContent()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		return parseCodeowners(content), nil
+	}
+	return nil, nil
+}
+
+// fetchPRFiles lists the paths of every file a PR changes as of its head
+// commit, reusing the list fetched for the same commit.
+func fetchPRFiles(ctx context.Context, client *github.Client, owner, repo string, number int, headSHA string) ([]string, error) {
+	key := prPartitionKey(owner, repo, number) + "@" + headSHA
+	if headSHA != "" {
+		if paths, ok := prFilesCache.get(key); ok {
+			return paths, nil
+		}
+	}
+
+	var paths []string
+	opts := &github.ListOptions{PerPage: 100}
+	for range maxPRFilePages {
+		files, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files: %w", err)
+		}
+		for _, file := range files {
+			paths = append(paths, file.GetFilename())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if headSHA != "" {
+		prFilesCache.set(key, paths)
+	}
+	return paths, nil
+}
+
+// slackUsersForOwner maps a CODEOWNERS owner to Slack users: a login
+// through its identity link, a team through its members' links, and an
+// email address through Slack's email lookup. Members in skip (lowercased
+// logins, e.g. the PR author) are left out.
+func slackUsersForOwner(ctx context.Context, owner, repo, codeOwner string, skip map[string]bool) ([]string, error) {
+	if !strings.HasPrefix(codeOwner, "@") {
+		user, err := slackClient.GetUserByEmailContext(ctx, codeOwner)
+		var slackErr slack.SlackErrorResponse
+		if errors.As(err, &slackErr) && slackErr.Err == "users_not_found" {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []string{user.ID}, nil
+	}
+
+	logins := []string{strings.TrimPrefix(codeOwner, "@")}
+	if org, slug, ok := strings.Cut(logins[0], "/"); ok {
+		client, err := githubClientFor(ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+		logins, err = fetchTeamMembers(ctx, client, org, slug)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var userIDs []string
+	for _, login := range logins {
+		if skip[strings.ToLower(login)] {
+			continue
+		}
+		uid, err := slackUserForGitHubLogin(ctx, owner, repo, login)
+		if err != nil {
+			return nil, err
+		}
+		if uid != "" {
+			userIDs = append(userIDs, uid)
+		}
+	}
+	return userIDs, nil
+}
+
+// fetchTeamMembers lists the logins of every member of an org's team,
+// reusing a recently fetched list.
+func fetchTeamMembers(ctx context.Context, client *github.Client, org, slug string) ([]string, error) {
+	key := strings.ToLower(org + "/" + slug)
+	if logins, ok := teamMembersCache.get(key); ok {
+		return logins, nil
+	}
+
+	var logins []string
+	opts := &github.TeamListTeamMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		members, resp, err := client.Teams.ListTeamMembersBySlug(ctx, org, slug, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list members of @%s/%s: %w", org, slug, err)
+		}
+		for _, member := range members {
+			logins = append(logins, member.GetLogin())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	teamMembersCache.set(key, logins)
+	return logins, nil
+}
+
+// codeOwnersApproved reports whether a PR has the code owner approvals
+// GitHub requires before merging: every changed file with owners must be
+// approved by one of them, directly or through a team. Owners given as
+// email addresses can't be matched to GitHub logins, so a file owned only
+// by email addresses doesn't hold the PR up.
+func codeOwnersApproved(ctx context.Context, pr *db.PullRequest, approvers []string) (bool, error) {
+	if len(approvers) == 0 {
+		return false, nil
+	}
+
+	client, err := githubClientFor(ctx, pr.GithubOwner, pr.GithubRepo)
+	if err != nil {
+		return false, err
+	}
+
+	rules, err := fetchCodeowners(ctx, client, pr.GithubOwner, pr.GithubRepo, pr.BaseBranch)
+	if err != nil || len(rules) == 0 {
+		return err == nil, err
+	}
+
+	files, err := fetchPRFiles(ctx, client, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, pr.HeadSHA)
+	if err != nil {
+		return false, err
+	}
+
+	approved := make(map[string]bool)
+	for _, login := range approvers {
+		approved[strings.ToLower(login)] = true
+	}
+
+	// Whether each owner has approved, so teams are only listed once
+	ownerApproved := make(map[string]bool)
+	for _, file := range files {
+		satisfied, verifiable := false, false
+		for _, owner := range ownersOf(rules, file) {
+			if !strings.HasPrefix(owner, "@") {
+				continue
+			}
+			verifiable = true
+
+			ok, seen := ownerApproved[owner]
+			if !seen {
+				name := strings.TrimPrefix(owner, "@")
+				if org, slug, isTeam := strings.Cut(name, "/"); isTeam {
+					members, err := fetchTeamMembers(ctx, client, org, slug)
+					if err != nil {
+						return false, err
+					}
+					ok = slices.ContainsFunc(members, func(m string) bool { return approved[strings.ToLower(m)] })
+				} else {
+					ok = approved[strings.ToLower(name)]
+				}
+				ownerApproved[owner] = ok
+			}
+			if ok {
+				satisfied = true
+				break
+			}
+		}
+		if verifiable && !satisfied {
+			return false, nil
+		}
+	}
+	return true, nil
+}
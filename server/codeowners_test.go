@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/v83/github"
+)
+
+func TestCodeownersPattern(t *testing.T) {
+	// Examples from GitHub's CODEOWNERS documentation
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*", "README.md", true},
+		{"*", "src/deep/main.go", true},
+		{"*.js", "app.js", true},
+		{"*.js", "web/src/app.js", true},
+		{"*.js", "app.jsx", false},
+		{"/build/logs/", "build/logs/today.log", true},
+		{"/build/logs/", "build/logs/2026/today.log", true},
+		{"/build/logs/", "src/build/logs/today.log", false},
+		{"docs/*", "docs/getting-started.md", true},
+		{"docs/*", "docs/build-app/troubleshooting.md", false},
+		{"docs/*", "src/docs/getting-started.md", false},
+		{"apps/", "apps/web/index.js", true},
+		{"apps/", "src/apps/web/index.js", true},
+		{"apps/", "apps", false},
+		{"/docs/", "docs/guide/intro.md", true},
+		{"/docs/", "src/docs/intro.md", false},
+		{"**/logs", "build/logs/today.log", true},
+		{"**/logs", "deeply/nested/logs/today.log", true},
+		{"**/logs", "logs/today.log", true},
+		{"**/logs", "catalogs/today.log", false},
+		{"/apps/github", "apps/github/hooks.js", true},
+		{"/apps/github", "apps/githubber/hooks.js", false},
+		{"src/**/test.go", "src/test.go", true},
+		{"src/**/test.go", "src/a/b/test.go", true},
+		{"file?.txt", "file1.txt", true},
+		{"file?.txt", "file/.txt", false},
+	}
+
+	for _, tt := range tests {
+		re, err := codeownersPattern(tt.pattern)
+		if err != nil {
+			t.Errorf("codeownersPattern(%q): %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOwnersOf(t *testing.T) {
+	rules := parseCodeowners(`
+# Default owners
+*       @global-owner1 @global-owner2
+*.js    @js-owner # inline comment
+/apps/  @octocat
+/apps/github
+docs/*  docs@example.com
+\#notes.md       @notes-owner
+issue#1/         @issue-owner	# tab before the comment
+`)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"README.md", []string{"@global-owner1", "@global-owner2"}},
+		{"web/app.js", []string{"@js-owner"}},
+		{"apps/web/app.js", []string{"@octocat"}}, // last match wins
+		{"apps/github/app.js", nil},               // explicitly unowned
+		{"docs/intro.md", []string{"docs@example.com"}},
+		{"docs/build-app/troubleshooting.md", []string{"@global-owner1", "@global-owner2"}},
+		{"#notes.md", []string{"@notes-owner"}},
+		{"issue#1/fix.go", []string{"@issue-owner"}},
+	}
+
+	for _, tt := range tests {
+		if got := ownersOf(rules, tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("ownersOf(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFetchPRFilesCachedByHeadCommit(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`[{"filename": "src/main.go"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(srv.Client())
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	ctx := context.Background()
+
+	for _, headSHA := range []string{"aaa", "aaa", "bbb"} {
+		files, err := fetchPRFiles(ctx, client, "octo", "cache-test", 1, headSHA)
+		if err != nil {
+			t.Fatalf("fetchPRFiles: %v", err)
+		}
+		if !slices.Equal(files, []string{"src/main.go"}) {
+			t.Fatalf("fetchPRFiles = %q", files)
+		}
+	}
+
+	// The repeat of the first commit is served from the cache
+	if got := requests.Load(); got != 2 {
+		t.Errorf("made %d requests to GitHub, want 2", got)
+	}
+}
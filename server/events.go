@@ -0,0 +1,62 @@
+package server
+
+import (
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+// eventKind identifies what happened to a PR, independent of whether
+// Revue heard about it from a webhook, polling or reconciliation.
+type eventKind string
+
+const (
+	eventReviewSubmitted eventKind = "review_submitted"
+	eventReviewDismissed eventKind = "review_dismissed"
+	eventPRClosed        eventKind = "pr_closed"
+	eventPRReopened      eventKind = "pr_reopened"
+	eventPRSynchronized  eventKind = "pr_synchronized" // new commits pushed
+	eventPRDraftChanged  eventKind = "pr_draft_changed"
+	eventPRBaseChanged   eventKind = "pr_base_changed"
+	eventPRSnapshot      eventKind = "pr_snapshot" // full state fetched from GitHub
+	eventCICheckUpdated  eventKind = "ci_check_updated"
+)
+
+// prEvent is a single transport-agnostic change to a PR. Which fields are
+// set depends on Kind. PRs are identified by Owner/Repo/Number, except CI
+// events, which only know the commit and are matched by HeadSHA.
+type prEvent struct {
+	Kind   eventKind
+	Owner  string
+	Repo   string
+	Number int
+
+	// HeadSHA is the PR's head commit when the event was produced, if known.
+	HeadSHA string
+
+	Review   reviewRecord // eventReviewSubmitted, eventReviewDismissed
+	Merged   bool         // eventPRClosed
+	IsDraft  bool         // eventPRDraftChanged
+	Base     string       // eventPRBaseChanged: the new base branch
+	Snapshot *prSnapshot  // eventPRSnapshot
+
+	CheckName  string // eventCICheckUpdated
+	CheckState string // eventCICheckUpdated: "pending", "success" or "failure"
+}
+
+// reviewRecord is one reviewer's review of a PR.
+type reviewRecord struct {
+	Login       string
+	State       string // "approved", "changes_requested", "commented" or "dismissed"
+	CommitID    string
+	SubmittedAt time.Time
+}
+
+// prSnapshot is GitHub's current view of a PR: its details and state, and
+// its reviews, oldest first.
+type prSnapshot struct {
+	Meta    db.PullRequestMetadata
+	State   string // "open" or "closed"
+	Merged  bool
+	Reviews []reviewRecord
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// installationTokenRefreshMargin is how long before expiry a cached
+// installation token is replaced. GitHub issues tokens valid for an hour.
+const installationTokenRefreshMargin = 5 * time.Minute
+
+// githubApp authenticates as a GitHub App: it signs short-lived JWTs with
+// the App's private key and exchanges them for per-installation tokens,
+// which are cached until shortly before they expire.
+type githubApp struct {
+	appID int64
+	key   *rsa.PrivateKey
+
+	mu     sync.Mutex
+	tokens map[int64]installationToken // keyed by installation ID
+	// refreshing serialises token requests per installation, so concurrent
+	// callers share one new token without blocking other installations
+	refreshing map[int64]*sync.Mutex
+}
+
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// ghApp is set when Revue is configured as a GitHub App. When nil, REST
+// calls fall back to githubClient.
+var ghApp *githubApp
+
+// newGitHubApp parses a PEM-encoded private key (PKCS#1 as downloaded from
+// GitHub, or PKCS#8) for the given App ID.
+func newGitHubApp(appID int64, privateKeyPEM []byte) (*githubApp, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		rsaKey, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		key = rsaKey
+	}
+
+	return &githubApp{
+		appID:      appID,
+		key:        key,
+		tokens:     make(map[int64]installationToken),
+		refreshing: make(map[int64]*sync.Mutex),
+	}, nil
+}
+
+// jwt mints an RS256 JWT identifying the App. It's backdated a minute to
+// allow for clock drift and expires well within GitHub's 10 minute limit.
+func (a *githubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign JWT: %w", err)
+	}
+
+	return signingInput + "." + enc.EncodeToString(signature), nil
+}
+
+// appClient returns a client authenticated as the App itself, which can
+// only call the /app endpoints.
+func (a *githubApp) appClient() (*github.Client, error) {
+	token, err := a.jwt(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(nil).WithAuthToken(token), nil
+}
+
+// installationToken returns a token for an installation, reusing the
+// cached one until it's close to expiring. Only one token is requested at
+// a time per installation; a.mu isn't held while GitHub is called.
+func (a *githubApp) installationToken(ctx context.Context, installationID int64) (string, error) {
+	a.mu.Lock()
+	refresh, ok := a.refreshing[installationID]
+	if !ok {
+		refresh = &sync.Mutex{}
+		a.refreshing[installationID] = refresh
+	}
+	a.mu.Unlock()
+
+	refresh.Lock()
+	defer refresh.Unlock()
+
+	// Another caller may have refreshed the token while we waited
+	if token, ok := a.cachedToken(installationID); ok {
+		return token, nil
+	}
+
+	client, err := a.appClient()
+	if err != nil {
+		return "", err
+	}
+
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	a.mu.Lock()
+	a.tokens[installationID] = installationToken{
+		token:     token.GetToken(),
+		expiresAt: token.GetExpiresAt().Time,
+	}
+	a.mu.Unlock()
+	return token.GetToken(), nil
+}
+
+// cachedToken returns an installation's cached token if it isn't close to
+// expiring.
+func (a *githubApp) cachedToken(installationID int64) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cached, ok := a.tokens[installationID]
+	if !ok || time.Until(cached.expiresAt) <= installationTokenRefreshMargin {
+		return "", false
+	}
+	return cached.token, true
+}
+
+// forgetInstallation drops a cached token, e.g. once the App is uninstalled.
+func (a *githubApp) forgetInstallation(installationID int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.tokens, installationID)
+}
+
+// githubClientFor returns a REST client that can read the given repo:
+// an installation-scoped client in GitHub App mode, githubClient otherwise.
+// Repos we haven't seen an installation webhook for are looked up on GitHub
+// and remembered.
+func githubClientFor(ctx context.Context, owner, repo string) (*github.Client, error) {
+	if ghApp == nil {
+		return githubClient, nil
+	}
+
+	installationID, err := db.FindInstallationForRepo(database, owner, repo)
+	if errors.Is(err, sql.ErrNoRows) {
+		installationID, err = discoverInstallation(ctx, owner, repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := ghApp.installationToken(ctx, installationID)
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(nil).WithAuthToken(token), nil
+}
+
+// githubClientForUsers returns a REST client for looking up GitHub users
+// rather than a repo: one scoped to any of the App's installations in
+// GitHub App mode, githubClient otherwise.
+func githubClientForUsers(ctx context.Context) (*github.Client, error) {
+	if ghApp == nil {
+		return githubClient, nil
+	}
+
+	installationID, err := db.FindAnyInstallation(database)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("the GitHub App isn't installed anywhere")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := ghApp.installationToken(ctx, installationID)
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(nil).WithAuthToken(token), nil
+}
+
+// discoverInstallation asks GitHub which installation covers a repo and
+// records it. GitHub answers 404 if the App isn't installed there.
+func discoverInstallation(ctx context.Context, owner, repo string) (int64, error) {
+	client, err := ghApp.appClient()
+	if err != nil {
+		return 0, err
+	}
+
+	installation, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := db.UpsertInstallation(database, installation.GetID(), installation.GetAccount().GetLogin()); err != nil {
+		return 0, fmt.Errorf("failed to record installation: %w", err)
+	}
+	if err := db.AddInstallationRepo(database, installation.GetID(), owner, repo); err != nil {
+		return 0, fmt.Errorf("failed to record installation repo: %w", err)
+	}
+
+	return installation.GetID(), nil
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// githubRequestTimeout bounds GitHub API calls made in the background.
+const githubRequestTimeout = 5 * time.Second
+
+// githubValidationTimeout bounds the GitHub calls made while a slash
+// command or modal submission waits for an answer, leaving time to spare
+// within the 3 seconds Slack allows. Past it, Slack shows an error while
+// the handler carries on, and resubmitting a modal would track the PRs
+// twice.
+const githubValidationTimeout = 2500 * time.Millisecond
+
+// githubClient talks to the GitHub REST API when Revue isn't running as a
+// GitHub App. It's authenticated with GITHUB_TOKEN when set and anonymous
+// (public repos only) otherwise. Use githubClientFor rather than this
+// directly.
+var githubClient *github.Client
+
+// newGitHubClient builds the REST client, authenticating with token if given.
+func newGitHubClient(token string) *github.Client {
+	client := github.NewClient(nil)
+	if token != "" {
+		client = client.WithAuthToken(token)
+	}
+	return client
+}
+
+// fetchPRMetadata looks a PR up on GitHub and returns the details we store,
+// along with the full API response for callers that need its state.
+func fetchPRMetadata(ctx context.Context, owner, repo string, number int) (db.PullRequestMetadata, *github.PullRequest, error) {
+	client, err := githubClientFor(ctx, owner, repo)
+	if err != nil {
+		return db.PullRequestMetadata{}, nil, err
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, owner, repo, number)
+	if err != nil {
+		return db.PullRequestMetadata{}, nil, err
+	}
+
+	return prMetadata(pr), pr, nil
+}
+
+// prMetadata extracts the details we store from a GitHub PR.
+func prMetadata(pr *github.PullRequest) db.PullRequestMetadata {
+	return db.PullRequestMetadata{
+		Title:       pr.GetTitle(),
+		AuthorLogin: pr.GetUser().GetLogin(),
+		BaseBranch:  pr.GetBase().GetRef(),
+		Additions:   pr.GetAdditions(),
+		Deletions:   pr.GetDeletions(),
+		HeadSHA:     pr.GetHead().GetSHA(),
+		IsDraft:     pr.GetDraft(),
+	}
+}
+
+// isNotFound reports whether err is a 404 from the GitHub API. GitHub also
+// answers 404 for private repos the token can't see.
+func isNotFound(err error) bool {
+	var ghErr *github.ErrorResponse
+	return errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusNotFound
+}
+
+// validateTrackablePRs fetches each PR from GitHub, all at once, and checks
+// it exists and is open. prs[i] must come from the input block
+// "pr_url_block_<i>", which is where any problem is reported. On success,
+// the metadata for each PR is returned in the same order.
+func validateTrackablePRs(prs []parsedPR) ([]db.PullRequestMetadata, map[string]string) {
+	ctx, cancel := context.WithTimeout(context.Background(), githubValidationTimeout)
+	defer cancel()
+
+	metas := make([]db.PullRequestMetadata, len(prs))
+	problems := make([]string, len(prs))
+	var wg sync.WaitGroup
+	for i, pr := range prs {
+		wg.Go(func() {
+			metas[i], problems[i] = validateTrackablePR(ctx, pr)
+		})
+	}
+	wg.Wait()
+
+	for i, problem := range problems {
+		if problem != "" {
+			return nil, map[string]string{fmt.Sprintf("pr_url_block_%d", i): problem}
+		}
+	}
+	return metas, nil
+}
+
+// validateTrackablePR fetches a PR from GitHub and checks it exists and is
+// open. Returns a problem to show the user if it can't be tracked.
+func validateTrackablePR(ctx context.Context, pr parsedPR) (db.PullRequestMetadata, string) {
+	meta, ghPR, err := fetchPRMetadata(ctx, pr.Owner, pr.Repo, pr.Number)
+	if isNotFound(err) {
+		return meta, fmt.Sprintf("%s/%s#%d doesn't exist or Revue can't access it", pr.Owner, pr.Repo, pr.Number)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return meta, "GitHub took too long to answer, please try again"
+	}
+	if err != nil {
+		return meta, fmt.Sprintf("Couldn't fetch the PR from GitHub: %v", err)
+	}
+
+	if ghPR.GetState() != "open" {
+		state := "closed"
+		if ghPR.GetMerged() {
+			state = "merged"
+		}
+		return meta, fmt.Sprintf("%s/%s#%d is already %s", pr.Owner, pr.Repo, pr.Number, state)
+	}
+	return meta, ""
+}
+
+// errBranchProtectionForbidden means a branch's classic protection can't
+// be read (GitHub only shows it to admins), so its requirement is unknown.
+var errBranchProtectionForbidden = errors.New("not allowed to read branch protection")
+
+// fetchApprovalRequirement works out how many approvals GitHub requires
+// to merge into a branch, from its classic branch protection and any
+// rulesets that apply to it, taking the strictest of them. Returns a
+// "default" requirement of 1 if the branch has neither, and
+// errBranchProtectionForbidden if its protection can't be read.
+func fetchApprovalRequirement(ctx context.Context, owner, repo, branch string) (db.ApprovalRequirement, error) {
+	client, err := githubClientFor(ctx, owner, repo)
+	if err != nil {
+		return db.ApprovalRequirement{}, err
+	}
+
+	required := 0
+	codeOwners := false
+
+	protection, _, err := client.Repositories.GetBranchProtection(ctx, owner, repo, branch)
+	switch {
+	case err == nil:
+		if reviews := protection.GetRequiredPullRequestReviews(); reviews != nil {
+			required = reviews.RequiredApprovingReviewCount
+			codeOwners = reviews.RequireCodeOwnerReviews
+		}
+	case errors.Is(err, github.ErrBranchNotProtected), isNotFound(err):
+		// Unprotected; rulesets may still apply
+	case isForbidden(err):
+		return db.ApprovalRequirement{}, errBranchProtectionForbidden
+	default:
+		return db.ApprovalRequirement{}, fmt.Errorf("failed to get branch protection: %w", err)
+	}
+
+	rules, _, err := client.Repositories.GetRulesForBranch(ctx, owner, repo, branch, &github.ListOptions{PerPage: 100})
+	if err != nil && !isNotFound(err) {
+		return db.ApprovalRequirement{}, fmt.Errorf("failed to get branch rules: %w", err)
+	}
+	if rules != nil {
+		for _, rule := range rules.PullRequest {
+			required = max(required, rule.Parameters.RequiredApprovingReviewCount)
+			codeOwners = codeOwners || rule.Parameters.RequireCodeOwnerReview
+		}
+	}
+
+	if required == 0 && !codeOwners {
+		return db.ApprovalRequirement{Required: 1, Source: "default"}, nil
+	}
+	// A required code owner review is itself an approval
+	return db.ApprovalRequirement{Required: max(required, 1), CodeOwnerReview: codeOwners, Source: "github"}, nil
+}
+
+// isForbidden reports whether a GitHub API error is a 403 Forbidden.
+func isForbidden(err error) bool {
+	var ghErr *github.ErrorResponse
+	return errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusForbidden
+}
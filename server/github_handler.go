@@ -1,10 +1,14 @@
 package server
 
 import (
-	"database/sql"
-	"errors"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
 	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dylfrancis/revue/db"
 	"github.com/google/go-github/v83/github"
@@ -21,8 +25,7 @@ func handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// ParseWebHook reads the X-GitHub-Event header to determine the event
-	// type, then unmarshals the payload into the appropriate typed struct.
+	// Parse now so malformed payloads are rejected rather than retried
 	eventType := github.WebHookType(r)
 	event, err := github.ParseWebHook(eventType, payload)
 	if err != nil {
@@ -31,106 +34,209 @@ func handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Type switch — Go's way of handling polymorphism. ParseWebHook returns
-	// interface{}, and we switch on the concrete type to handle each event.
-	switch e := event.(type) {
-	case *github.PullRequestReviewEvent:
-		handlePRReview(e)
-	case *github.PullRequestEvent:
-		handlePRStateChange(e)
-	default:
-		log.Printf("Ignoring GitHub event type: %s", eventType)
-	}
-
-	w.WriteHeader(http.StatusOK)
-}
-
-// handlePRReview processes pull_request_review events.
-// When a review is submitted with an "approved" state, we increment the
-// approval count and update the Slack tracker message.
-func handlePRReview(event *github.PullRequestReviewEvent) {
-	// Only care about newly submitted reviews that are approvals
-	if event.GetAction() != "submitted" {
+	// Queue the webhook and acknowledge it straight away; a worker applies
+	// it (see processGitHubWebhook) well within GitHub's delivery timeout.
+	job := githubWebhookJob{EventType: eventType, Payload: payload}
+	partitionKey := webhookPartitionKey(event)
+	deliveryID := github.DeliveryID(r)
+	if deliveryID == "" {
+		if err := enqueueJob(jobGitHubWebhook, job, "", partitionKey); err != nil {
+			log.Printf("Failed to queue GitHub webhook: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
-	if event.GetReview().GetState() != "approved" {
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		log.Printf("Failed to encode GitHub webhook: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
-	owner := event.GetRepo().GetOwner().GetLogin()
-	repo := event.GetRepo().GetName()
-	prNumber := event.GetPullRequest().GetNumber()
-
-	pr, err := db.FindPullRequest(database, owner, repo, prNumber)
-	if errors.Is(err, sql.ErrNoRows) {
-		return // PR not tracked by us, ignore
-	}
+	// Redeliveries carry the original delivery's GUID. Applying one again
+	// could undo newer state (e.g. re-record a review that has since been
+	// dismissed), so anything already seen is acknowledged and dropped.
+	queued, err := db.EnqueueGitHubDelivery(database, deliveryID, jobGitHubWebhook, string(body), partitionKey, time.Now())
 	if err != nil {
-		log.Printf("Failed to find PR %s/%s#%d: %v", owner, repo, prNumber, err)
+		log.Printf("Failed to queue GitHub webhook %s: %v", deliveryID, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
-
-	// Increment approval count
-	newApprovals := pr.ApprovalsCurrent + 1
-	if err := db.UpdatePullRequestApprovals(database, pr.ID, newApprovals); err != nil {
-		log.Printf("Failed to update approvals for PR %d: %v", pr.ID, err)
+	if !queued {
+		log.Printf("Ignoring duplicate GitHub delivery %s", deliveryID)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	// If approvals meet the threshold, mark as approved
-	if newApprovals >= pr.ApprovalsRequired && pr.Status == "open" {
-		if err := db.UpdatePullRequestStatus(database, pr.ID, "approved"); err != nil {
-			log.Printf("Failed to update PR status: %v", err)
-			return
-		}
+	notifyJobWorkers()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// webhookPartitionKey returns the job partition for a webhook, so webhooks
+// about the same PR (or, for CI, the same commit) are applied one at a time
+// in the order they arrived. Otherwise concurrent workers or retries could
+// apply e.g. "closed" after a later "reopened". Other webhooks don't need
+// ordering and get "".
+func webhookPartitionKey(event any) string {
+	events, _ := webhookEvents(event)
+	if len(events) == 0 {
+		return ""
 	}
 
-	if err := updateTrackerMessage(pr.TrackerID); err != nil {
-		log.Printf("Failed to update tracker message: %v", err)
+	ev := events[0]
+	if ev.Number != 0 {
+		return prPartitionKey(ev.Owner, ev.Repo, ev.Number)
 	}
+	return commitPartitionKey(ev.Owner, ev.Repo, ev.HeadSHA)
 }
 
-// handlePRStateChange processes pull_request events (opened, closed, merged, etc.).
-// We only care about the "closed" action — GitHub uses "closed" for both
-// merges and closes, and we check the Merged field to distinguish them.
-func handlePRStateChange(event *github.PullRequestEvent) {
-	if event.GetAction() != "closed" {
-		return
-	}
+// prPartitionKey identifies a PR for ordering and locking its changes.
+func prPartitionKey(owner, repo string, number int) string {
+	return fmt.Sprintf("%s#%d", strings.ToLower(owner+"/"+repo), number)
+}
 
-	owner := event.GetRepo().GetOwner().GetLogin()
-	repo := event.GetRepo().GetName()
-	prNumber := event.GetPullRequest().GetNumber()
+// commitPartitionKey identifies a commit for ordering changes to its CI.
+func commitPartitionKey(owner, repo, sha string) string {
+	return strings.ToLower(owner+"/"+repo) + "@" + sha
+}
 
-	pr, err := db.FindPullRequest(database, owner, repo, prNumber)
-	if errors.Is(err, sql.ErrNoRows) {
-		return // not tracked
-	}
-	if err != nil {
-		log.Printf("Failed to find PR %s/%s#%d: %v", owner, repo, prNumber, err)
-		return
+// prLocks serialise applying changes to a PR between the webhook workers
+// and the reconciler. PRs share a fixed set of locks by hash, which keeps
+// the set bounded at the cost of the odd unrelated wait.
+var prLocks [64]sync.Mutex
+
+// lockPR locks the PR identified by a partition key and returns the
+// function that unlocks it.
+func lockPR(key string) func() {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	mu := &prLocks[h.Sum32()%uint32(len(prLocks))]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// githubDeliveryTTL is how long delivery GUIDs are remembered. GitHub only
+// allows redelivering webhooks from the past few days.
+const githubDeliveryTTL = 7 * 24 * time.Hour
+
+// deliveryCleanupInterval is how often expired delivery GUIDs are deleted.
+const deliveryCleanupInterval = time.Hour
+
+// runDeliveryCleanup deletes expired delivery GUIDs on every interval
+// until the process exits.
+func runDeliveryCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := db.DeleteGitHubDeliveriesBefore(database, time.Now().Add(-githubDeliveryTTL))
+		if err != nil {
+			log.Printf("Failed to delete expired GitHub deliveries: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Deleted %d expired GitHub deliveries", deleted)
+		}
 	}
+}
 
-	status := "closed"
-	if event.GetPullRequest().GetMerged() {
-		status = "merged"
+// webhookEvents translates a parsed webhook into PR events. Returns false
+// if the webhook type isn't one Revue handles; handled webhooks may still
+// translate to no events (e.g. a check suite that hasn't completed).
+func webhookEvents(event any) ([]prEvent, bool) {
+	// Type switch — Go's way of handling polymorphism. ParseWebHook returns
+	// interface{}, and we switch on the concrete type to handle each event.
+	switch e := event.(type) {
+	case *github.PullRequestReviewEvent:
+		return prReviewEvents(e), true
+	case *github.PullRequestEvent:
+		return prStateEvents(e), true
+	case *github.CheckSuiteEvent:
+		return checkSuiteEvents(e), true
+	case *github.CheckRunEvent:
+		return checkRunEvents(e), true
+	case *github.StatusEvent:
+		return commitStatusEvents(e), true
+	default:
+		return nil, false
 	}
+}
 
-	if err := db.UpdatePullRequestStatus(database, pr.ID, status); err != nil {
-		log.Printf("Failed to update PR status: %v", err)
-		return
+// prReviewEvents translates pull_request_review events.
+// Submitted approvals, change requests and comments are recorded against
+// the reviewer's GitHub login, and dismissed reviews revoke whatever that
+// reviewer last left.
+func prReviewEvents(event *github.PullRequestReviewEvent) []prEvent {
+	review := event.GetReview()
+
+	kind := eventReviewSubmitted
+	state := review.GetState()
+	switch event.GetAction() {
+	case "submitted":
+		if state != "approved" && state != "changes_requested" && state != "commented" {
+			return nil
+		}
+	case "dismissed":
+		kind = eventReviewDismissed
+		state = "dismissed"
+	default:
+		return nil
 	}
 
-	// Check if all PRs in the tracker are done
-	completed, err := db.CompleteTrackerIfDone(database, pr.TrackerID)
-	if err != nil {
-		log.Printf("Failed to check tracker completion: %v", err)
+	ev := newPREvent(kind, event.GetRepo(), event.GetPullRequest())
+	ev.Review = reviewRecord{
+		Login:       review.GetUser().GetLogin(),
+		State:       state,
+		CommitID:    review.GetCommitID(),
+		SubmittedAt: review.GetSubmittedAt().Time,
 	}
-	if completed {
-		log.Printf("Tracker %d completed — all PRs merged/closed", pr.TrackerID)
+	return []prEvent{ev}
+}
+
+// prStateEvents translates pull_request events (opened, closed, merged, etc.).
+// GitHub uses "closed" for both merges and closes, and we check the Merged
+// field to distinguish them. "synchronize" means new commits were pushed,
+// "converted_to_draft" / "ready_for_review" toggle the PR's draft flag, and
+// "edited" matters when the PR was retargeted to another base branch.
+func prStateEvents(event *github.PullRequestEvent) []prEvent {
+	ghPR := event.GetPullRequest()
+
+	var ev prEvent
+	switch event.GetAction() {
+	case "closed":
+		ev = newPREvent(eventPRClosed, event.GetRepo(), ghPR)
+		ev.Merged = ghPR.GetMerged()
+	case "reopened":
+		ev = newPREvent(eventPRReopened, event.GetRepo(), ghPR)
+	case "synchronize":
+		ev = newPREvent(eventPRSynchronized, event.GetRepo(), ghPR)
+	case "converted_to_draft", "ready_for_review":
+		ev = newPREvent(eventPRDraftChanged, event.GetRepo(), ghPR)
+		ev.IsDraft = event.GetAction() == "converted_to_draft"
+	case "edited":
+		// Only retargeting matters; title and body edits are picked up by
+		// reconciliation
+		if event.GetChanges().GetBase().GetRef().From == nil {
+			return nil
+		}
+		ev = newPREvent(eventPRBaseChanged, event.GetRepo(), ghPR)
+		ev.Base = ghPR.GetBase().GetRef()
+	default:
+		return nil
 	}
+	return []prEvent{ev}
+}
 
-	if err := updateTrackerMessage(pr.TrackerID); err != nil {
-		log.Printf("Failed to update tracker message: %v", err)
+// newPREvent starts an event about a PR from a webhook's repo and PR.
+func newPREvent(kind eventKind, repo *github.Repository, ghPR *github.PullRequest) prEvent {
+	return prEvent{
+		Kind:    kind,
+		Owner:   repo.GetOwner().GetLogin(),
+		Repo:    repo.GetName(),
+		Number:  ghPR.GetNumber(),
+		HeadSHA: ghPR.GetHead().GetSHA(),
 	}
 }
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+const testWebhookSecret = "test-secret"
+
+// postWebhook sends a signed webhook to handleGitHubWebhook and returns the
+// response status.
+func postWebhook(t *testing.T, eventType, deliveryID, payload string) int {
+	t.Helper()
+
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write([]byte(payload))
+
+	req := httptest.NewRequest(http.MethodPost, "/github/webhook", bytes.NewBufferString(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", eventType)
+	req.Header.Set("X-GitHub-Delivery", deliveryID)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	rec := httptest.NewRecorder()
+	handleGitHubWebhook(rec, req)
+	return rec.Code
+}
+
+// runWebhookJobs runs every queued webhook job. Other jobs (tracker
+// refreshes, which post to Slack) are dropped.
+func runWebhookJobs(t *testing.T) {
+	t.Helper()
+
+	for {
+		job, err := db.ClaimJob(database, time.Now())
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			t.Fatalf("ClaimJob: %v", err)
+		}
+		if job.Kind == jobGitHubWebhook {
+			if err := runJob(job); err != nil {
+				t.Fatalf("run webhook job: %v", err)
+			}
+		}
+		if err := db.CompleteJob(database, job.ID); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+	}
+}
+
+func TestGitHubWebhookIgnoresRedeliveries(t *testing.T) {
+	testDB := newTestDB(t)
+	previousSecret := githubWebhookSecret
+	githubWebhookSecret = testWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret = previousSecret })
+
+	prID := trackTestPR(t, "octo", "app", 7)
+
+	const approval = `{
+		"action": "submitted",
+		"review": {"state": "approved", "user": {"login": "alice"}, "commit_id": "abc", "submitted_at": "2026-01-02T10:00:00Z"},
+		"pull_request": {"number": 7, "head": {"sha": "abc"}},
+		"repository": {"name": "app", "owner": {"login": "octo"}}
+	}`
+	const dismissal = `{
+		"action": "dismissed",
+		"review": {"state": "dismissed", "user": {"login": "alice"}, "commit_id": "abc", "submitted_at": "2026-01-02T10:00:00Z"},
+		"pull_request": {"number": 7, "head": {"sha": "abc"}},
+		"repository": {"name": "app", "owner": {"login": "octo"}}
+	}`
+
+	approvals := func() int {
+		t.Helper()
+		pr, err := db.GetPullRequestByID(database, prID)
+		if err != nil {
+			t.Fatalf("GetPullRequestByID: %v", err)
+		}
+		return pr.ApprovalsCurrent
+	}
+	webhookJobs := func() int {
+		t.Helper()
+		var n int
+		if err := testDB.QueryRow("SELECT COUNT(*) FROM jobs WHERE kind = ?", jobGitHubWebhook).Scan(&n); err != nil {
+			t.Fatalf("count jobs: %v", err)
+		}
+		return n
+	}
+
+	if code := postWebhook(t, "pull_request_review", "delivery-1", approval); code != http.StatusAccepted {
+		t.Fatalf("first delivery: status %d, want %d", code, http.StatusAccepted)
+	}
+	if code := postWebhook(t, "pull_request_review", "delivery-1", approval); code != http.StatusOK {
+		t.Fatalf("redelivery: status %d, want %d", code, http.StatusOK)
+	}
+	if n := webhookJobs(); n != 1 {
+		t.Fatalf("queued %d webhook jobs, want 1", n)
+	}
+	runWebhookJobs(t)
+	if got := approvals(); got != 1 {
+		t.Fatalf("approvals after approval = %d, want 1", got)
+	}
+
+	// The dismissal carries the approval's submitted_at, so applying the
+	// approval again would restore it; the redelivery must be dropped
+	postWebhook(t, "pull_request_review", "delivery-2", dismissal)
+	runWebhookJobs(t)
+	if got := approvals(); got != 0 {
+		t.Fatalf("approvals after dismissal = %d, want 0", got)
+	}
+
+	if code := postWebhook(t, "pull_request_review", "delivery-1", approval); code != http.StatusOK {
+		t.Fatalf("late redelivery: status %d, want %d", code, http.StatusOK)
+	}
+	if n := webhookJobs(); n != 0 {
+		t.Fatalf("queued %d webhook jobs for a redelivery, want 0", n)
+	}
+	runWebhookJobs(t)
+	if got := approvals(); got != 0 {
+		t.Errorf("approvals after redelivery = %d, want 0", got)
+	}
+}
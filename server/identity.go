@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+	"github.com/slack-go/slack"
+)
+
+// autoLinkUsersByEmail enables matching unlinked GitHub logins to Slack
+// users whose profile email matches one the login commits with.
+var autoLinkUsersByEmail bool
+
+// autoLinkRetryInterval is how long to wait before trying to match a
+// GitHub login by email again after it didn't match anyone.
+const autoLinkRetryInterval = 24 * time.Hour
+
+// autoLinkMisses records when each GitHub login last failed to match, so
+// every review from an unmatched login doesn't cost more API calls.
+var autoLinkMisses = struct {
+	mu sync.Mutex
+	at map[string]time.Time
+}{at: make(map[string]time.Time)}
+
+// slackUserForGitHubLogin returns the Slack user linked to a GitHub login,
+// trying to link it by email first if it isn't linked yet and automatic
+// linking is enabled. Returns "" if the login can't be attributed to
+// anyone. owner/repo is where the login was seen, used to find its
+// commit emails.
+func slackUserForGitHubLogin(ctx context.Context, owner, repo, login string) (string, error) {
+	link, err := db.GetUserLink(database, login)
+	if err == nil {
+		return link.SlackUserID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to look up link for %s: %w", login, err)
+	}
+
+	if !autoLinkUsersByEmail || recentlyMissed(login) {
+		return "", nil
+	}
+
+	slackUserID, err := matchSlackUserByEmail(ctx, owner, repo, login)
+	if err != nil {
+		return "", fmt.Errorf("failed to match %s by email: %w", login, err)
+	}
+	if slackUserID == "" {
+		autoLinkMisses.mu.Lock()
+		autoLinkMisses.at[strings.ToLower(login)] = time.Now()
+		autoLinkMisses.mu.Unlock()
+		return "", nil
+	}
+
+	if err := db.LinkUser(database, login, slackUserID, "email"); err != nil {
+		return "", fmt.Errorf("failed to link %s: %w", login, err)
+	}
+	return slackUserID, nil
+}
+
+// recentlyMissed reports whether a login failed to match by email within
+// autoLinkRetryInterval.
+func recentlyMissed(login string) bool {
+	autoLinkMisses.mu.Lock()
+	defer autoLinkMisses.mu.Unlock()
+
+	missedAt, ok := autoLinkMisses.at[strings.ToLower(login)]
+	return ok && time.Since(missedAt) < autoLinkRetryInterval
+}
+
+// matchSlackUserByEmail looks for a Slack user whose profile email is the
+// GitHub user's public email or one of their recent commit emails in
+// owner/repo. Returns "" if nobody matches.
+func matchSlackUserByEmail(ctx context.Context, owner, repo, login string) (string, error) {
+	client, err := githubClientFor(ctx, owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	var emails []string
+	user, _, err := client.Users.Get(ctx, login)
+	if err != nil {
+		return "", fmt.Errorf("failed to get GitHub user: %w", err)
+	}
+	if user.GetEmail() != "" {
+		emails = append(emails, user.GetEmail())
+	}
+
+	commits, _, err := client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{
+		Author:      login,
+		ListOptions: github.ListOptions{PerPage: 20},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, commit := range commits {
+		emails = append(emails, commit.GetCommit().GetAuthor().GetEmail())
+	}
+
+	seen := make(map[string]bool)
+	for _, email := range emails {
+		email = strings.ToLower(email)
+		// GitHub's private noreply addresses never belong to a Slack profile
+		if email == "" || seen[email] || strings.HasSuffix(email, "@users.noreply.github.com") {
+			continue
+		}
+		seen[email] = true
+
+		slackUser, err := slackClient.GetUserByEmailContext(ctx, email)
+		var slackErr slack.SlackErrorResponse
+		if errors.As(err, &slackErr) && slackErr.Err == "users_not_found" {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to look up Slack user by email: %w", err)
+		}
+		if !slackUser.IsBot && !slackUser.Deleted {
+			return slackUser.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// handleLinkCommand links the caller's Slack account to a GitHub login so
+// their reviews on GitHub are attributed to them. The login is checked
+// against GitHub. A login someone else has linked manually can't be
+// claimed, and one matched to someone else by email can only be claimed
+// by a user whose Slack email is the login's public GitHub email, so
+// nobody can take over another reviewer's approvals.
+func handleLinkCommand(w http.ResponseWriter, cmd slashCommand) {
+	login := strings.TrimPrefix(cmd.Args[0], "@")
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubValidationTimeout)
+	defer cancel()
+
+	client, err := githubClientForUsers(ctx)
+	if err != nil {
+		log.Printf("Failed to get a GitHub client to look up %s: %v", login, err)
+		respondEphemeral(w, "Sorry, I couldn't reach GitHub to check that login.")
+		return
+	}
+
+	user, _, err := client.Users.Get(ctx, login)
+	if isNotFound(err) {
+		respondEphemeral(w, fmt.Sprintf("GitHub user `%s` doesn't exist.", login))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get GitHub user %s: %v", login, err)
+		respondEphemeral(w, "Sorry, I couldn't reach GitHub to check that login.")
+		return
+	}
+	login = user.GetLogin() // canonical capitalisation
+
+	existing, err := db.GetUserLink(database, login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Failed to look up link for %s: %v", login, err)
+		respondEphemeral(w, "Sorry, something went wrong linking your account.")
+		return
+	}
+	if existing != nil && existing.SlackUserID != cmd.UserID {
+		if existing.Source == "manual" {
+			respondEphemeral(w, fmt.Sprintf("`%s` is already linked to <@%s>.", login, existing.SlackUserID))
+			return
+		}
+
+		owns, err := slackEmailMatches(ctx, cmd.UserID, user.GetEmail())
+		if err != nil {
+			log.Printf("Failed to check %s's email against %s: %v", cmd.UserID, login, err)
+			respondEphemeral(w, "Sorry, something went wrong linking your account.")
+			return
+		}
+		if !owns {
+			respondEphemeral(w, fmt.Sprintf("`%s` is linked to <@%s> because their email matches. "+
+				"To claim it, your Slack email must be the login's public GitHub email.", login, existing.SlackUserID))
+			return
+		}
+	}
+
+	if err := db.LinkUser(database, login, cmd.UserID, "manual"); err != nil {
+		log.Printf("Failed to link %s to %s: %v", login, cmd.UserID, err)
+		respondEphemeral(w, "Sorry, something went wrong linking your account.")
+		return
+	}
+
+	respondEphemeral(w, fmt.Sprintf("Linked GitHub user `%s` to you. Your reviews on GitHub will now show up as yours.", login))
+}
+
+// slackEmailMatches reports whether a Slack user's profile email is email.
+// Returns false if either is unknown (reading Slack emails needs the
+// users:read.email scope).
+func slackEmailMatches(ctx context.Context, slackUserID, email string) (bool, error) {
+	if email == "" {
+		return false, nil
+	}
+
+	user, err := slackClient.GetUserInfoContext(ctx, slackUserID)
+	if err != nil {
+		return false, err
+	}
+	return user.Profile.Email != "" && strings.EqualFold(user.Profile.Email, email), nil
+}
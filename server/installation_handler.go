@@ -0,0 +1,86 @@
+package server
+
+import (
+	"log"
+	"strings"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// handleInstallation processes installation events, keeping track of which
+// accounts the GitHub App is installed on and which repos it can see.
+func handleInstallation(event *github.InstallationEvent) {
+	installation := event.GetInstallation()
+	installationID := installation.GetID()
+
+	var err error
+	switch event.GetAction() {
+	case "created":
+		err = db.UpsertInstallation(database, installationID, installation.GetAccount().GetLogin())
+		if err == nil {
+			addInstallationRepos(installationID, event.Repositories)
+		}
+	case "deleted":
+		err = db.DeleteInstallation(database, installationID)
+		if ghApp != nil {
+			ghApp.forgetInstallation(installationID)
+		}
+	case "suspend":
+		err = db.SetInstallationSuspended(database, installationID, true)
+	case "unsuspend":
+		err = db.SetInstallationSuspended(database, installationID, false)
+	default:
+		return
+	}
+
+	if err != nil {
+		log.Printf("Failed to handle installation %s for %d: %v", event.GetAction(), installationID, err)
+		return
+	}
+	log.Printf("GitHub App installation %d on %s: %s", installationID, installation.GetAccount().GetLogin(), event.GetAction())
+}
+
+// handleInstallationRepositories processes installation_repositories
+// events, sent when repos are added to or removed from an installation.
+func handleInstallationRepositories(event *github.InstallationRepositoriesEvent) {
+	installation := event.GetInstallation()
+
+	// The installation may predate Revue, so make sure we know about it
+	if err := db.UpsertInstallation(database, installation.GetID(), installation.GetAccount().GetLogin()); err != nil {
+		log.Printf("Failed to record installation %d: %v", installation.GetID(), err)
+		return
+	}
+
+	addInstallationRepos(installation.GetID(), event.RepositoriesAdded)
+
+	for _, r := range event.RepositoriesRemoved {
+		owner, repo, ok := splitFullName(r.GetFullName())
+		if !ok {
+			continue
+		}
+		if err := db.RemoveInstallationRepo(database, installation.GetID(), owner, repo); err != nil {
+			log.Printf("Failed to remove repo %s from installation %d: %v", r.GetFullName(), installation.GetID(), err)
+		}
+	}
+}
+
+// addInstallationRepos records every repo an installation was granted.
+func addInstallationRepos(installationID int64, repos []*github.Repository) {
+	for _, r := range repos {
+		owner, repo, ok := splitFullName(r.GetFullName())
+		if !ok {
+			continue
+		}
+		if err := db.AddInstallationRepo(database, installationID, owner, repo); err != nil {
+			log.Printf("Failed to add repo %s to installation %d: %v", r.GetFullName(), installationID, err)
+		}
+	}
+}
+
+// splitFullName splits "owner/repo". Installation payloads only include
+// each repo's full name, not a separate owner.
+func splitFullName(fullName string) (owner, repo string, ok bool) {
+	owner, repo, ok = strings.Cut(fullName, "/")
+	return owner, repo, ok && owner != "" && repo != ""
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+	"github.com/slack-go/slack"
+)
+
+// Job kinds. GitHub webhooks are queued as received so the HTTP handler can
+// acknowledge them immediately; applying one queues a refresh of each
+// tracker it touched, so Slack failures are retried without re-applying
+// the webhook. Newly tracked PRs queue a lookup of their base branch's
+// approval requirement and of the CI already reported on their head
+// commit rather than delaying the modal response.
+const (
+	jobGitHubWebhook       = "github_webhook"
+	jobTrackerPost         = "tracker_post"
+	jobTrackerRefresh      = "tracker_refresh"
+	jobApprovalRequirement = "approval_requirement"
+	jobCIStatus            = "ci_status"
+)
+
+const (
+	// jobWorkers is how many jobs are processed concurrently.
+	jobWorkers = 4
+
+	// jobPollInterval is how often idle workers check for due jobs, e.g.
+	// retries whose backoff has elapsed.
+	jobPollInterval = 5 * time.Second
+
+	// jobMaxAttempts is how many times a job is tried before giving up.
+	jobMaxAttempts = 8
+
+	// jobBaseBackoff is the delay before the first retry; it doubles with
+	// every attempt up to jobMaxBackoff.
+	jobBaseBackoff = 5 * time.Second
+	jobMaxBackoff  = 30 * time.Minute
+)
+
+// jobsQueued wakes an idle worker when a job is enqueued.
+var jobsQueued = make(chan struct{}, 1)
+
+// githubWebhookJob is the payload of a jobGitHubWebhook job: the raw
+// webhook as GitHub sent it.
+type githubWebhookJob struct {
+	EventType string          `json:"event_type"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// enqueueJob adds a job to run as soon as a worker is free, and after any
+// earlier job in its partition (see db.EnqueueJob).
+func enqueueJob(kind string, payload any, dedupKey, partitionKey string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s job: %w", kind, err)
+	}
+
+	if err := db.EnqueueJob(database, kind, string(body), dedupKey, partitionKey, time.Now()); err != nil {
+		return fmt.Errorf("failed to enqueue %s job: %w", kind, err)
+	}
+
+	notifyJobWorkers()
+	return nil
+}
+
+// notifyJobWorkers wakes an idle worker to pick up a newly queued job.
+func notifyJobWorkers() {
+	select {
+	case jobsQueued <- struct{}{}:
+	default: // a wake-up is already pending
+	}
+}
+
+// enqueueTrackerPost queues the first post of a new tracker's Slack
+// message.
+func enqueueTrackerPost(trackerID int64) error {
+	return enqueueJob(jobTrackerPost, trackerID, "", trackerPartitionKey(trackerID))
+}
+
+// enqueueTrackerRefresh queues an update of a tracker's Slack message.
+// Refreshes always render the latest state, so a tracker has at most one
+// pending refresh at a time.
+func enqueueTrackerRefresh(trackerID int64) error {
+	return enqueueJob(jobTrackerRefresh, trackerID, "tracker_refresh:"+strconv.FormatInt(trackerID, 10), trackerPartitionKey(trackerID))
+}
+
+// trackerPartitionKey is the job partition for a tracker's message, so
+// refreshes wait for its first post and never race each other.
+func trackerPartitionKey(trackerID int64) string {
+	return "tracker:" + strconv.FormatInt(trackerID, 10)
+}
+
+// runJobWorkers requeues jobs interrupted by a restart and starts the
+// worker pool.
+func runJobWorkers(workers int) {
+	reset, err := db.ResetProcessingJobs(database)
+	if err != nil {
+		log.Printf("Failed to requeue interrupted jobs: %v", err)
+	}
+	if reset > 0 {
+		log.Printf("Requeued %d interrupted jobs", reset)
+	}
+
+	for range workers {
+		go runJobWorker()
+	}
+}
+
+// runJobWorker processes jobs until the process exits, waiting for a new
+// job or the poll interval whenever the queue has nothing due.
+func runJobWorker() {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for processNextJob() {
+		}
+
+		select {
+		case <-jobsQueued:
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextJob claims and runs one due job. Returns false if there was
+// nothing to run.
+func processNextJob() bool {
+	job, err := db.ClaimJob(database, time.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to claim job: %v", err)
+		return false
+	}
+
+	if err := runJob(job); err != nil {
+		retryJob(job, err)
+		return true
+	}
+
+	if err := db.CompleteJob(database, job.ID); err != nil {
+		log.Printf("Failed to complete job %d: %v", job.ID, err)
+	}
+	return true
+}
+
+// runJob dispatches a job to its handler.
+func runJob(job *db.Job) error {
+	switch job.Kind {
+	case jobGitHubWebhook:
+		var webhook githubWebhookJob
+		if err := json.Unmarshal([]byte(job.Payload), &webhook); err != nil {
+			return fmt.Errorf("failed to decode webhook: %w", err)
+		}
+		return processGitHubWebhook(webhook.EventType, webhook.Payload)
+	case jobTrackerPost:
+		var trackerID int64
+		if err := json.Unmarshal([]byte(job.Payload), &trackerID); err != nil {
+			return fmt.Errorf("failed to decode tracker ID: %w", err)
+		}
+		return postNewTracker(trackerID)
+	case jobTrackerRefresh:
+		var trackerID int64
+		if err := json.Unmarshal([]byte(job.Payload), &trackerID); err != nil {
+			return fmt.Errorf("failed to decode tracker ID: %w", err)
+		}
+		return updateTrackerMessage(trackerID)
+	case jobApprovalRequirement:
+		var prID int64
+		if err := json.Unmarshal([]byte(job.Payload), &prID); err != nil {
+			return fmt.Errorf("failed to decode PR ID: %w", err)
+		}
+		return refreshApprovalRequirement(prID)
+	case jobCIStatus:
+		var prID int64
+		if err := json.Unmarshal([]byte(job.Payload), &prID); err != nil {
+			return fmt.Errorf("failed to decode PR ID: %w", err)
+		}
+		return refreshCIStatus(prID)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// retryJob schedules a failed job to run again with exponential backoff,
+// or gives up on it after jobMaxAttempts.
+func retryJob(job *db.Job, jobErr error) {
+	if job.Attempts >= jobMaxAttempts {
+		log.Printf("Giving up on %s job %d after %d attempts: %v", job.Kind, job.ID, job.Attempts, jobErr)
+		if err := db.FailJob(database, job.ID, jobErr.Error()); err != nil {
+			log.Printf("Failed to mark job %d as failed: %v", job.ID, err)
+		}
+		if job.Kind == jobTrackerPost {
+			var trackerID int64
+			if err := json.Unmarshal([]byte(job.Payload), &trackerID); err == nil {
+				abandonTrackerPost(trackerID)
+			}
+		}
+		return
+	}
+
+	delay := jobBackoff(job.Attempts)
+	// Respect Slack's Retry-After when we've been rate limited
+	var rateErr *slack.RateLimitedError
+	if errors.As(jobErr, &rateErr) && rateErr.RetryAfter > delay {
+		delay = rateErr.RetryAfter
+	}
+
+	log.Printf("Failed to run %s job %d (attempt %d), retrying in %s: %v", job.Kind, job.ID, job.Attempts, delay, jobErr)
+	if err := db.RetryJob(database, job.ID, time.Now().Add(delay), jobErr.Error()); err != nil {
+		log.Printf("Failed to reschedule job %d: %v", job.ID, err)
+	}
+}
+
+// jobBackoff returns the delay before retrying a job that has failed
+// attempts times.
+func jobBackoff(attempts int) time.Duration {
+	delay := jobBaseBackoff
+	for i := 1; i < attempts && delay < jobMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, jobMaxBackoff)
+}
+
+// processGitHubWebhook applies a queued webhook. Installation events
+// manage GitHub App credentials rather than PRs, so they're handled
+// directly instead of going through the processor.
+func processGitHubWebhook(eventType string, payload []byte) error {
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s webhook: %w", eventType, err)
+	}
+
+	switch e := event.(type) {
+	case *github.InstallationEvent:
+		handleInstallation(e)
+		return nil
+	case *github.InstallationRepositoriesEvent:
+		handleInstallationRepositories(e)
+		return nil
+	}
+
+	events, ok := webhookEvents(event)
+	if !ok {
+		log.Printf("Ignoring GitHub event type: %s", eventType)
+		return nil
+	}
+
+	// Hold off reconciliation of the PR while its webhook is applied
+	if key := webhookPartitionKey(event); key != "" {
+		unlock := lockPR(key)
+		defer unlock()
+	}
+	return processEvents(events)
+}
+
+// refreshApprovalRequirement looks up a tracked PR's approval requirement
+// on GitHub and refreshes its tracker if it changed.
+func refreshApprovalRequirement(prID int64) error {
+	pr, err := db.GetPullRequestByID(database, prID)
+	if err != nil {
+		return fmt.Errorf("failed to get PR: %w", err)
+	}
+
+	changed, err := applyApprovalRequirement(pr)
+	if err != nil || !changed {
+		return err
+	}
+	return enqueueTrackerRefresh(pr.TrackerID)
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+func TestTrackerPostRetriesThenTellsCreator(t *testing.T) {
+	newTestDB(t)
+	// Every unthreaded post fails, as if Revue weren't in the channel
+	fake := newFakeSlack(t, "")
+
+	trackerID, err := db.CreateTracker(database, "C123", "U123")
+	if err != nil {
+		t.Fatalf("CreateTracker: %v", err)
+	}
+	if err := enqueueTrackerPost(trackerID); err != nil {
+		t.Fatalf("enqueueTrackerPost: %v", err)
+	}
+
+	if !processNextJob() {
+		t.Fatal("processNextJob found no job")
+	}
+	tracker, err := db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		t.Fatalf("GetTrackerByID: %v", err)
+	}
+	if tracker.Status != "active" || tracker.SlackMessageTS != "" {
+		t.Fatalf("after a failed post, tracker = %q with TS %q, want active and unposted", tracker.Status, tracker.SlackMessageTS)
+	}
+
+	// The retry is backing off; run it as the last attempt
+	job, err := db.ClaimJob(database, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ClaimJob: %v", err)
+	}
+	if job.Kind != jobTrackerPost {
+		t.Fatalf("claimed %s job, want %s", job.Kind, jobTrackerPost)
+	}
+	job.Attempts = jobMaxAttempts
+	retryJob(job, errors.New("not_in_channel"))
+
+	tracker, err = db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		t.Fatalf("GetTrackerByID: %v", err)
+	}
+	if tracker.Status != "untracked" {
+		t.Errorf("after giving up, tracker status = %q, want untracked", tracker.Status)
+	}
+	// The failed post, then the message to the creator
+	if got := len(fake.posts()); got != 2 {
+		t.Errorf("made %d posts, want 2", got)
+	}
+}
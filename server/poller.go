@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// pollRateLimitReserve is how many requests the poller leaves in the rate
+// limit budget for everything else (tracking PRs from Slack, etc.). Below
+// it, polling pauses until the limit resets.
+const pollRateLimitReserve = 100
+
+// poller ingests GitHub state by polling, for deployments that can't
+// receive webhooks. Every request is conditional on the ETag of the last
+// response, so unchanged PRs cost nothing against the rate limit, and
+// polling backs off when the remaining budget runs low.
+type poller struct {
+	cache map[string]cachedResponse // keyed by request URL
+	// pending holds responses fetched for the PR being polled. They only
+	// replace the cached ones once the PR's changes have been applied, so
+	// a change that fails to apply isn't answered with 304 next time.
+	pending map[string]cachedResponse
+	seen    map[string]bool // URLs requested during the current pass
+	now     func() time.Time
+	sleep   func(time.Duration)
+}
+
+// cachedResponse is the last body GitHub sent for a URL, replayed when
+// GitHub answers 304 Not Modified.
+type cachedResponse struct {
+	etag string
+	body json.RawMessage
+}
+
+func newPoller() *poller {
+	return &poller{
+		cache:   make(map[string]cachedResponse),
+		pending: make(map[string]cachedResponse),
+		seen:    make(map[string]bool),
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+}
+
+// run polls every tracked PR on each interval until the process exits.
+func (p *poller) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	p.pollAll()
+	for range ticker.C {
+		p.pollAll()
+	}
+}
+
+// pollAll polls every PR on an active tracker, applying each PR's changes
+// as soon as it's fetched, and refreshes the Slack message of each
+// tracker that changed.
+func (p *poller) pollAll() {
+	prs, err := db.GetPullRequestsOnActiveTrackers(database)
+	if err != nil {
+		log.Printf("Failed to load PRs to poll: %v", err)
+		return
+	}
+
+	p.seen = make(map[string]bool)
+
+	for _, pr := range prs {
+		p.pollAndApply(pr)
+	}
+
+	// Drop cached responses for PRs that are no longer tracked
+	for url := range p.cache {
+		if !p.seen[url] {
+			delete(p.cache, url)
+		}
+	}
+}
+
+// pollAndApply polls a PR and applies any changes while holding the PR's
+// lock, so a snapshot can't land between (and undo) webhooks for the PR.
+// The PR's responses are only cached once its changes are applied.
+func (p *poller) pollAndApply(pr db.PullRequest) {
+	unlock := lockPR(prPartitionKey(pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber))
+	defer unlock()
+
+	p.pending = make(map[string]cachedResponse)
+
+	ev, changed, err := p.pollPR(&pr)
+	if err != nil {
+		log.Printf("Failed to poll %s/%s#%d: %v", pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, err)
+		return
+	}
+	if changed {
+		if err := processEvents([]prEvent{ev}); err != nil {
+			log.Printf("Failed to process GitHub changes for %s/%s#%d: %v", pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, err)
+			return
+		}
+	}
+
+	for url, resp := range p.pending {
+		p.cache[url] = resp
+	}
+}
+
+// pollPR fetches a PR and its reviews and, if GitHub reports anything new,
+// returns a snapshot event for the processor. Returns false if nothing
+// changed since the last poll.
+func (p *poller) pollPR(pr *db.PullRequest) (prEvent, bool, error) {
+	// No overall deadline: a pass may pause for the rate limit part way
+	// through. Each request gets its own timeout in get.
+	ctx := context.Background()
+
+	clientCtx, cancel := context.WithTimeout(ctx, githubRequestTimeout)
+	client, err := githubClientFor(clientCtx, pr.GithubOwner, pr.GithubRepo)
+	cancel()
+	if err != nil {
+		return prEvent{}, false, err
+	}
+
+	var ghPR github.PullRequest
+	prURL := fmt.Sprintf("repos/%s/%s/pulls/%d", pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber)
+	prChanged, err := p.get(ctx, client, prURL, &ghPR)
+	if err != nil {
+		return prEvent{}, false, err
+	}
+
+	var reviews []*github.PullRequestReview
+	reviewsChanged := false
+	for page := 1; page != 0; {
+		var batch []*github.PullRequestReview
+		reviewsURL := fmt.Sprintf("repos/%s/%s/pulls/%d/reviews?per_page=100&page=%d",
+			pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, page)
+		changed, err := p.get(ctx, client, reviewsURL, &batch)
+		if err != nil {
+			return prEvent{}, false, err
+		}
+		reviewsChanged = reviewsChanged || changed
+		reviews = append(reviews, batch...)
+
+		page++
+		if len(batch) < 100 {
+			page = 0
+		}
+	}
+
+	if !prChanged && !reviewsChanged {
+		return prEvent{}, false, nil
+	}
+
+	return snapshotEvent(pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, &prSnapshot{
+		Meta:    prMetadata(&ghPR),
+		State:   ghPR.GetState(),
+		Merged:  ghPR.GetMerged(),
+		Reviews: snapshotReviews(reviews),
+	}), true, nil
+}
+
+// get fetches a GitHub API URL into v, sending the cached ETag so GitHub
+// can answer 304 Not Modified. Returns true if the body changed since the
+// last poll; the new body is staged in p.pending. Waits out the rate limit
+// first if the budget is exhausted.
+func (p *poller) get(ctx context.Context, client *github.Client, url string, v any) (bool, error) {
+	p.seen[url] = true
+
+	req, err := client.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, githubRequestTimeout)
+	defer cancel()
+
+	cached, hasCached := p.cache[url]
+	if hasCached {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	var body json.RawMessage
+	resp, err := client.Do(ctx, req, &body)
+	p.respectRateLimit(resp, err)
+
+	var errResp *github.ErrorResponse
+	if errors.As(err, &errResp) && errResp.Response != nil &&
+		errResp.Response.StatusCode == http.StatusNotModified && hasCached {
+		return false, json.Unmarshal(cached.body, v)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		p.pending[url] = cachedResponse{etag: etag, body: body}
+	}
+	return true, json.Unmarshal(body, v)
+}
+
+// respectRateLimit pauses polling when GitHub says we're rate limited or
+// the remaining budget has dropped to the reserve.
+func (p *poller) respectRateLimit(resp *github.Response, err error) {
+	var wait time.Duration
+
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		wait = rateErr.Rate.Reset.Sub(p.now())
+	case errors.As(err, &abuseErr):
+		wait = abuseErr.GetRetryAfter()
+		if wait == 0 {
+			wait = time.Minute
+		}
+	case resp != nil && resp.Rate.Limit > 0 && resp.Rate.Remaining <= pollRateLimitReserve:
+		wait = resp.Rate.Reset.Sub(p.now())
+	}
+
+	if wait <= 0 {
+		return
+	}
+	log.Printf("GitHub rate limit low, pausing polling for %s", wait.Round(time.Second))
+	p.sleep(wait)
+}
@@ -0,0 +1,449 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+// processEvents applies events and then queues a refresh of the Slack
+// message of every tracker they touched. Every event is attempted even if
+// an earlier one fails; the errors are returned together.
+func processEvents(events []prEvent) error {
+	var errs []error
+	changedTrackers := make(map[int64]bool)
+	for _, ev := range events {
+		trackerIDs, err := applyEvent(ev)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to apply %s event for %s/%s#%d: %w", ev.Kind, ev.Owner, ev.Repo, ev.Number, err))
+		}
+		for _, id := range trackerIDs {
+			changedTrackers[id] = true
+		}
+	}
+
+	for trackerID := range changedTrackers {
+		if err := enqueueTrackerRefresh(trackerID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// applyEvent applies an event to every tracked copy of its PR and returns
+// the IDs of trackers whose messages need refreshing. It updates the
+// database, reading from GitHub where an event needs more than it carries;
+// updating Slack is left to the caller.
+func applyEvent(ev prEvent) ([]int64, error) {
+	var prs []db.PullRequest
+	var err error
+	if ev.Kind == eventCICheckUpdated {
+		prs, err = db.FindPullRequestsByHeadSHA(database, ev.Owner, ev.Repo, ev.HeadSHA)
+	} else {
+		prs, err = db.FindPullRequests(database, ev.Owner, ev.Repo, ev.Number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tracked PRs: %w", err)
+	}
+	if len(prs) == 0 {
+		return nil, nil // not tracked by us, ignore
+	}
+
+	if ev.Kind == eventCICheckUpdated {
+		if err := db.UpsertCICheck(database, ev.Owner, ev.Repo, ev.HeadSHA, ev.CheckName, ev.CheckState); err != nil {
+			return nil, fmt.Errorf("failed to record CI check %s: %w", ev.CheckName, err)
+		}
+	}
+
+	var trackerIDs []int64
+	seen := make(map[int64]bool)
+	for i := range prs {
+		pr := &prs[i]
+
+		changed, err := applyToPR(pr, ev)
+		if err != nil {
+			return trackerIDs, fmt.Errorf("PR %d: %w", pr.ID, err)
+		}
+		if changed && !seen[pr.TrackerID] {
+			seen[pr.TrackerID] = true
+			trackerIDs = append(trackerIDs, pr.TrackerID)
+		}
+	}
+	return trackerIDs, nil
+}
+
+// applyToPR applies an event to a single tracked PR row. Returns true if
+// the tracker message should be refreshed.
+func applyToPR(pr *db.PullRequest, ev prEvent) (bool, error) {
+	if err := recordHeadSHA(pr, ev.HeadSHA); err != nil {
+		return false, err
+	}
+
+	switch ev.Kind {
+	case eventReviewSubmitted, eventReviewDismissed:
+		if err := applyReview(pr, ev.Review); err != nil {
+			return false, err
+		}
+		attributeReview(pr, ev.Review)
+		return true, nil
+	case eventPRClosed:
+		return true, applyPRClosed(pr, ev.Merged)
+	case eventPRReopened:
+		return true, applyPRReopened(pr)
+	case eventPRSynchronized:
+		return true, applyPRSynchronized(pr, ev.HeadSHA)
+	case eventPRDraftChanged:
+		if err := db.UpdatePullRequestDraft(database, pr.ID, ev.IsDraft); err != nil {
+			return false, fmt.Errorf("failed to update draft flag: %w", err)
+		}
+		return true, nil
+	case eventPRBaseChanged:
+		if err := db.UpdatePullRequestBaseBranch(database, pr.ID, ev.Base); err != nil {
+			return false, fmt.Errorf("failed to update base branch: %w", err)
+		}
+		pr.BaseBranch = ev.Base
+		if _, err := applyApprovalRequirement(pr); err != nil {
+			return false, err
+		}
+		return true, nil
+	case eventPRSnapshot:
+		return applyPRSnapshot(pr, ev.Snapshot)
+	case eventCICheckUpdated:
+		return true, nil // recorded once per commit in applyEvent
+	default:
+		return false, fmt.Errorf("unknown event kind %q", ev.Kind)
+	}
+}
+
+// applyReview records a reviewer's latest review and re-derives the PR's
+// approvals and status. Dismissed reviews revoke whatever that reviewer
+// last left, while comments only count as having looked at the PR.
+func applyReview(pr *db.PullRequest, review reviewRecord) error {
+	if err := db.UpsertReview(database, pr.ID, review.Login, review.State, review.CommitID, review.SubmittedAt); err != nil {
+		return fmt.Errorf("failed to record review: %w", err)
+	}
+
+	// A fresh approval supersedes the "new commits since approval" warning
+	if review.State == "approved" && pr.NewCommitsSinceApproval {
+		if err := db.SetNewCommitsSinceApproval(database, pr.ID, false); err != nil {
+			return fmt.Errorf("failed to clear new-commits flag: %w", err)
+		}
+	}
+
+	return syncReviewStatus(pr)
+}
+
+// attributeReview adds the Slack user behind a review to the PR's
+// reviewers, so someone who reviews without having been asked still shows
+// up on the tracker. Attribution is best effort and never fails the event.
+func attributeReview(pr *db.PullRequest, review reviewRecord) {
+	if review.State != "approved" && review.State != "changes_requested" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	defer cancel()
+
+	slackUserID, err := slackUserForGitHubLogin(ctx, pr.GithubOwner, pr.GithubRepo, review.Login)
+	if err != nil {
+		log.Printf("Failed to attribute review by %s on PR %d: %v", review.Login, pr.ID, err)
+		return
+	}
+	if slackUserID == "" {
+		return
+	}
+
+	added, err := db.AddReviewer(database, pr.ID, slackUserID)
+	if err != nil {
+		log.Printf("Failed to add %s as a reviewer of PR %d: %v", slackUserID, pr.ID, err)
+		return
+	}
+	if added {
+		log.Printf("Added %s as a reviewer of PR %d from their GitHub review", slackUserID, pr.ID)
+	}
+}
+
+// syncReviewStatus recomputes a PR's approval count from its recorded
+// reviews and derives its status: any outstanding change request wins,
+// otherwise the PR is "approved" once it meets the threshold (and, where
+// GitHub requires it, a code owner has approved) and "open" until then.
+// Merged and closed PRs keep their status.
+func syncReviewStatus(pr *db.PullRequest) error {
+	approvals, err := db.CountReviewsByState(database, pr.ID, "approved")
+	if err != nil {
+		return fmt.Errorf("failed to count approvals: %w", err)
+	}
+
+	if err := db.UpdatePullRequestApprovals(database, pr.ID, approvals); err != nil {
+		return fmt.Errorf("failed to update approvals: %w", err)
+	}
+
+	if pr.Status == "merged" || pr.Status == "closed" {
+		return nil
+	}
+
+	changesRequested, err := db.CountReviewsByState(database, pr.ID, "changes_requested")
+	if err != nil {
+		return fmt.Errorf("failed to count change requests: %w", err)
+	}
+
+	status := "open"
+	if changesRequested > 0 {
+		status = "changes_requested"
+	} else if approvals >= pr.ApprovalsRequired {
+		status = "approved"
+		if pr.CodeOwnerReviewRequired {
+			ok, err := checkCodeOwnersApproved(pr)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				status = "open"
+			}
+		}
+	}
+	if status == pr.Status {
+		return nil
+	}
+
+	if err := db.UpdatePullRequestStatus(database, pr.ID, status); err != nil {
+		return fmt.Errorf("failed to update PR status: %w", err)
+	}
+	pr.Status = status
+	return nil
+}
+
+// checkCodeOwnersApproved reports whether the approvals recorded on a PR
+// include the code owner approvals GitHub requires.
+func checkCodeOwnersApproved(pr *db.PullRequest) (bool, error) {
+	approvers, err := db.GetReviewLoginsByState(database, pr.ID, "approved")
+	if err != nil {
+		return false, fmt.Errorf("failed to get approvers: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	defer cancel()
+
+	ok, err := codeOwnersApproved(ctx, pr, approvers)
+	if err != nil {
+		return false, fmt.Errorf("failed to check code owner approval: %w", err)
+	}
+	return ok, nil
+}
+
+// applyPRClosed marks a PR as merged or closed and completes its tracker
+// once every PR in it is done.
+func applyPRClosed(pr *db.PullRequest, merged bool) error {
+	status := "closed"
+	if merged {
+		status = "merged"
+	}
+
+	if err := db.UpdatePullRequestStatus(database, pr.ID, status); err != nil {
+		return fmt.Errorf("failed to update PR status: %w", err)
+	}
+	pr.Status = status
+
+	// Check if all PRs in the tracker are done
+	completed, err := db.CompleteTrackerIfDone(database, pr.TrackerID)
+	if err != nil {
+		return fmt.Errorf("failed to check tracker completion: %w", err)
+	}
+	if completed {
+		log.Printf("Tracker %d completed — all PRs merged/closed", pr.TrackerID)
+		forgetTrackerCIChecks(pr.TrackerID)
+	}
+	return nil
+}
+
+// applyPRReopened restores a reopened PR to "open", "approved" or
+// "changes_requested" based on its recorded reviews, and flips a completed
+// tracker back to active.
+func applyPRReopened(pr *db.PullRequest) error {
+	if err := db.UpdatePullRequestStatus(database, pr.ID, "open"); err != nil {
+		return fmt.Errorf("failed to update PR status: %w", err)
+	}
+	pr.Status = "open"
+
+	if err := syncReviewStatus(pr); err != nil {
+		return err
+	}
+
+	reactivated, err := db.ReactivateTracker(database, pr.TrackerID)
+	if err != nil {
+		return fmt.Errorf("failed to reactivate tracker: %w", err)
+	}
+	if reactivated {
+		log.Printf("Tracker %d reactivated — PR %d was reopened", pr.TrackerID, pr.ID)
+		// Its CI was forgotten when the tracker completed
+		if err := enqueueJob(jobCIStatus, pr.ID, "", commitPartitionKey(pr.GithubOwner, pr.GithubRepo, pr.HeadSHA)); err != nil {
+			log.Printf("Failed to queue CI status lookup: %v", err)
+		}
+	}
+	return nil
+}
+
+// applyPRSynchronized handles new commits pushed to a PR. If the tracker's
+// channel or the PR's repo has opted into the stale-approval policy, any
+// approval made against an older commit than headSHA is dismissed and the
+// PR is flagged as having new commits since approval.
+func applyPRSynchronized(pr *db.PullRequest, headSHA string) error {
+	dismissed, err := dismissStaleApprovals(pr, headSHA)
+	if err != nil {
+		return err
+	}
+	if dismissed == 0 {
+		// New commits can touch files whose code owners haven't approved
+		if pr.CodeOwnerReviewRequired {
+			return syncReviewStatus(pr)
+		}
+		return nil
+	}
+
+	if err := db.SetNewCommitsSinceApproval(database, pr.ID, true); err != nil {
+		return fmt.Errorf("failed to flag new commits: %w", err)
+	}
+
+	return syncReviewStatus(pr)
+}
+
+// dismissStaleApprovals dismisses approvals made against commits other
+// than headSHA if the stale-approval policy applies to the PR. Returns the
+// number of approvals dismissed.
+func dismissStaleApprovals(pr *db.PullRequest, headSHA string) (int64, error) {
+	tracker, err := db.GetTrackerByID(database, pr.TrackerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get tracker: %w", err)
+	}
+
+	enabled, err := db.StaleApprovalPolicyEnabled(database, tracker.SlackChannelID, pr.GithubOwner, pr.GithubRepo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check stale approval policy: %w", err)
+	}
+	if !enabled {
+		return 0, nil
+	}
+
+	dismissed, err := db.DismissApprovalsBeforeCommit(database, pr.ID, headSHA)
+	if err != nil {
+		return 0, fmt.Errorf("failed to dismiss stale approvals: %w", err)
+	}
+	return dismissed, nil
+}
+
+// applyApprovalRequirement sets how many approvals a PR needs from its
+// base branch's protection rules, unless it was set manually when the PR
+// was tracked or the rules can't be read, and re-derives its status.
+// Returns true if the requirement changed.
+func applyApprovalRequirement(pr *db.PullRequest) (bool, error) {
+	if pr.ApprovalsRequiredSource == "manual" {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	defer cancel()
+
+	approvals, err := fetchApprovalRequirement(ctx, pr.GithubOwner, pr.GithubRepo, pr.BaseBranch)
+	if errors.Is(err, errBranchProtectionForbidden) {
+		// Guessing would understate a protected branch's requirement
+		log.Printf("Can't read branch protection of %s/%s %s; keeping %s/%s#%d at %d required approvals",
+			pr.GithubOwner, pr.GithubRepo, pr.BaseBranch, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, pr.ApprovalsRequired)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch approval requirement: %w", err)
+	}
+
+	changed, err := db.UpdateApprovalRequirement(database, pr.ID, approvals)
+	if err != nil {
+		return false, fmt.Errorf("failed to update approval requirement: %w", err)
+	}
+	if !changed {
+		return false, nil
+	}
+	pr.ApprovalsRequired = approvals.Required
+	pr.CodeOwnerReviewRequired = approvals.CodeOwnerReview
+	pr.ApprovalsRequiredSource = approvals.Source
+
+	return true, syncReviewStatus(pr)
+}
+
+// applyPRSnapshot brings a tracked PR in line with GitHub's current view
+// of it, using the same transitions as individual events. Returns true if
+// anything shown in the tracker message changed.
+func applyPRSnapshot(pr *db.PullRequest, snapshot *prSnapshot) (bool, error) {
+	before := *pr
+	headChanged := snapshot.Meta.HeadSHA != pr.HeadSHA
+
+	if err := db.UpdatePullRequestMetadata(database, pr.ID, snapshot.Meta); err != nil {
+		return false, fmt.Errorf("failed to update metadata: %w", err)
+	}
+	if headChanged {
+		forgetCIChecks(pr.GithubOwner, pr.GithubRepo, before.HeadSHA)
+	}
+
+	// UpsertReview keeps each reviewer's latest review
+	for _, review := range snapshot.Reviews {
+		if err := db.UpsertReview(database, pr.ID, review.Login, review.State, review.CommitID, review.SubmittedAt); err != nil {
+			return false, fmt.Errorf("failed to record review: %w", err)
+		}
+	}
+
+	// GitHub still reports approvals on old commits as approved, so the
+	// stale-approval policy has to be re-applied on every snapshot.
+	dismissed, err := dismissStaleApprovals(pr, snapshot.Meta.HeadSHA)
+	if err != nil {
+		return false, err
+	}
+	if headChanged && dismissed > 0 {
+		if err := db.SetNewCommitsSinceApproval(database, pr.ID, true); err != nil {
+			return false, fmt.Errorf("failed to flag new commits: %w", err)
+		}
+	}
+
+	// Retargeted PRs are subject to the new base branch's rules
+	if before.BaseBranch != "" && snapshot.Meta.BaseBranch != before.BaseBranch {
+		pr.BaseBranch = snapshot.Meta.BaseBranch
+		if _, err := applyApprovalRequirement(pr); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	case snapshot.State == "closed" && pr.Status != "merged" && pr.Status != "closed":
+		err = applyPRClosed(pr, snapshot.Merged)
+	case snapshot.State == "open" && (pr.Status == "merged" || pr.Status == "closed"):
+		err = applyPRReopened(pr)
+	default:
+		err = syncReviewStatus(pr)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	after, err := db.GetPullRequestByID(database, pr.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to reload PR: %w", err)
+	}
+	*pr = *after
+
+	return *after != before, nil
+}
+
+// recordHeadSHA keeps a PR's stored head commit current so CI events,
+// which only carry a SHA, can be matched back to it.
+func recordHeadSHA(pr *db.PullRequest, headSHA string) error {
+	if headSHA == "" || headSHA == pr.HeadSHA {
+		return nil
+	}
+
+	if err := db.UpdatePullRequestHeadSHA(database, pr.ID, headSHA); err != nil {
+		return fmt.Errorf("failed to update head SHA: %w", err)
+	}
+	forgetCIChecks(pr.GithubOwner, pr.GithubRepo, pr.HeadSHA)
+	pr.HeadSHA = headSHA
+	return nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/google/go-github/v83/github"
+)
+
+// reconcileInterval is how often every tracked PR is re-checked against
+// GitHub, to catch webhooks that were missed while Revue was down or that
+// GitHub failed to deliver.
+const reconcileInterval = 30 * time.Minute
+
+// runReconciler reconciles immediately (catching up after downtime) and
+// then on every interval until the process exits.
+func runReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reconcileAll()
+	for range ticker.C {
+		reconcileAll()
+	}
+}
+
+// reconcileAll corrects every PR on an active tracker from GitHub's view
+// of it and refreshes the Slack message of each tracker that changed.
+// Each PR is locked against webhooks from fetching its snapshot until the
+// snapshot is applied, so a webhook applied in between (e.g. "closed")
+// can't be undone by the older snapshot.
+func reconcileAll() {
+	prs, err := db.GetPullRequestsOnActiveTrackers(database)
+	if err != nil {
+		log.Printf("Failed to load PRs to reconcile: %v", err)
+		return
+	}
+
+	// A PR can be on several trackers, but one snapshot event covers them all
+	fetched := make(map[string]bool)
+	for _, pr := range prs {
+		key := prPartitionKey(pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber)
+		if fetched[key] {
+			continue
+		}
+		fetched[key] = true
+
+		if err := reconcilePR(key, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber); err != nil {
+			log.Printf("Failed to reconcile %s: %v", key, err)
+		}
+	}
+}
+
+// reconcilePR fetches a snapshot of a PR and applies it while holding the
+// PR's lock.
+func reconcilePR(key, owner, repo string, number int) error {
+	unlock := lockPR(key)
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	snapshot, err := fetchPRSnapshot(ctx, owner, repo, number)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to fetch from GitHub: %w", err)
+	}
+
+	return processEvents([]prEvent{snapshotEvent(owner, repo, number, snapshot)})
+}
+
+// snapshotEvent wraps a snapshot of a PR as an event for the processor.
+func snapshotEvent(owner, repo string, number int, snapshot *prSnapshot) prEvent {
+	return prEvent{
+		Kind:     eventPRSnapshot,
+		Owner:    owner,
+		Repo:     repo,
+		Number:   number,
+		Snapshot: snapshot,
+	}
+}
+
+// fetchPRSnapshot fetches a PR and all of its reviews.
+func fetchPRSnapshot(ctx context.Context, owner, repo string, number int) (*prSnapshot, error) {
+	meta, ghPR, err := fetchPRMetadata(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := githubClientFor(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews []*github.PullRequestReview
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.PullRequests.ListReviews(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reviews: %w", err)
+		}
+		reviews = append(reviews, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	return &prSnapshot{
+		Meta:    meta,
+		State:   ghPR.GetState(),
+		Merged:  ghPR.GetMerged(),
+		Reviews: snapshotReviews(reviews),
+	}, nil
+}
+
+// snapshotReviews converts reviews listed by the GitHub API, oldest first,
+// into review records, skipping pending (unsubmitted) reviews.
+func snapshotReviews(reviews []*github.PullRequestReview) []reviewRecord {
+	var records []reviewRecord
+	for _, review := range reviews {
+		state := strings.ToLower(review.GetState())
+		if state != "approved" && state != "changes_requested" && state != "commented" && state != "dismissed" {
+			continue
+		}
+		records = append(records, reviewRecord{
+			Login:       review.GetUser().GetLogin(),
+			State:       state,
+			CommitID:    review.GetCommitID(),
+			SubmittedAt: review.GetSubmittedAt().Time,
+		})
+	}
+	return records
+}
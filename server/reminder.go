@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+// reminderCheckInterval is how often the scheduler wakes up to look for
+// channels whose reminder is due. Each channel still fires on its own
+// interval_minutes from the channel_reminders table.
+const reminderCheckInterval = time.Minute
+
+// reminderScheduler posts periodic nudges for stale trackers. The clock is
+// injectable so the schedule can be driven deterministically.
+type reminderScheduler struct {
+	now func() time.Time
+}
+
+func newReminderScheduler(now func() time.Time) *reminderScheduler {
+	return &reminderScheduler{now: now}
+}
+
+// run checks for due reminders on every tick until the process exits.
+func (s *reminderScheduler) run(checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	s.tick()
+	for range ticker.C {
+		s.tick()
+	}
+}
+
+// tick fires every reminder that is due at the scheduler's current time.
+func (s *reminderScheduler) tick() {
+	now := s.now()
+
+	reminders, err := db.GetEnabledChannelReminders(database)
+	if err != nil {
+		log.Printf("Failed to load channel reminders: %v", err)
+		return
+	}
+
+	for _, reminder := range reminders {
+		if !reminderDue(reminder, now) {
+			continue
+		}
+
+		// The reminder counts as fired even if some trackers failed, or
+		// every tracker that succeeded would be reminded again next tick
+		if err := sendChannelReminders(reminder.SlackChannelID, now); err != nil {
+			log.Printf("Failed to send reminders for channel %s: %v", reminder.SlackChannelID, err)
+		}
+
+		if err := db.UpdateChannelReminderLastFired(database, reminder.ID, now); err != nil {
+			log.Printf("Failed to record reminder %d as fired: %v", reminder.ID, err)
+		}
+	}
+}
+
+// reminderDue reports whether a channel reminder should fire at now.
+// A reminder that has never fired is always due.
+func reminderDue(reminder db.ChannelReminder, now time.Time) bool {
+	if !reminder.LastFiredAt.Valid {
+		return true
+	}
+	interval := time.Duration(reminder.IntervalMinutes) * time.Minute
+	return now.Sub(reminder.LastFiredAt.Time) >= interval
+}
+
+// sendChannelReminders posts a threaded reminder under every active tracker
+// in the channel that still has PRs waiting on review, skipping trackers
+// snoozed past now. A tracker that fails doesn't stop the rest; their
+// errors are returned together.
+func sendChannelReminders(channelID string, now time.Time) error {
+	trackers, err := db.GetActiveTrackersByChannel(database, channelID)
+	if err != nil {
+		return fmt.Errorf("failed to get trackers: %w", err)
+	}
+
+	var errs []error
+	for _, tracker := range trackers {
+		// The tracker message failed to post, so there's nothing to thread under
+		if tracker.SlackMessageTS == "" {
+			continue
+		}
+		if tracker.SnoozedUntil.Valid && now.Before(tracker.SnoozedUntil.Time) {
+			continue
+		}
+
+		text, err := buildReminderText(tracker.ID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tracker %d: %w", tracker.ID, err))
+			continue
+		}
+		if text == "" {
+			continue // nothing is waiting on review
+		}
+
+		_, _, err = slackClient.PostMessage(
+			tracker.SlackChannelID,
+			slack.MsgOptionText(text, false),
+			slack.MsgOptionTS(tracker.SlackMessageTS),
+		)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to post reminder for tracker %d: %w", tracker.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// buildReminderText lists every open, non-draft PR in a tracker along with
+// the reviewers it's still waiting on. Returns an empty string if no such
+// PR remains.
+func buildReminderText(trackerID int64) (string, error) {
+	prs, err := db.GetPullRequestsByTracker(database, trackerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get PRs: %w", err)
+	}
+
+	var lines []string
+	for _, pr := range prs {
+		// Drafts aren't ready for review yet, so don't nudge anyone about them
+		if pr.Status != "open" || pr.IsDraft {
+			continue
+		}
+
+		reviewers, err := db.GetReviewerStatuses(database, pr.ID)
+		if err != nil {
+			return "", fmt.Errorf("failed to get reviewers: %w", err)
+		}
+
+		// Reviewers who already approved or requested changes are done
+		var mentions []string
+		for _, reviewer := range reviewers {
+			if !reviewer.Finished() {
+				mentions = append(mentions, fmt.Sprintf("<@%s>", reviewer.SlackUserID))
+			}
+		}
+		if len(mentions) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — %s",
+			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+			strings.Join(mentions, " ")))
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	return ":alarm_clock: *Reminder* — these PRs are still waiting on review:\n" +
+		strings.Join(lines, "\n"), nil
+}
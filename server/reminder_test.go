@@ -0,0 +1,150 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+func TestReminderDue(t *testing.T) {
+	lastFired := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		reminder db.ChannelReminder
+		now      time.Time
+		want     bool
+	}{
+		{"never fired", db.ChannelReminder{IntervalMinutes: 60}, lastFired, true},
+		{"before interval", db.ChannelReminder{IntervalMinutes: 60, LastFiredAt: sql.NullTime{Time: lastFired, Valid: true}}, lastFired.Add(59 * time.Minute), false},
+		{"at interval", db.ChannelReminder{IntervalMinutes: 60, LastFiredAt: sql.NullTime{Time: lastFired, Valid: true}}, lastFired.Add(time.Hour), true},
+		{"past interval", db.ChannelReminder{IntervalMinutes: 60, LastFiredAt: sql.NullTime{Time: lastFired, Valid: true}}, lastFired.Add(3 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		if got := reminderDue(tt.reminder, tt.now); got != tt.want {
+			t.Errorf("%s: reminderDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// fakeSlack serves chat.postMessage, failing posts threaded under
+// failThreadTS, and records the thread of every post.
+type fakeSlack struct {
+	failThreadTS string
+
+	mu      sync.Mutex
+	threads []string
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	threadTS := r.FormValue("thread_ts")
+	f.mu.Lock()
+	f.threads = append(f.threads, threadTS)
+	f.mu.Unlock()
+
+	resp := map[string]any{"ok": true, "channel": r.FormValue("channel"), "ts": "2.2"}
+	if threadTS == f.failThreadTS {
+		resp = map[string]any{"ok": false, "error": "thread_not_found"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeSlack) posts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.threads...)
+}
+
+// newFakeSlack points the Slack client at a fakeSlack for the duration of
+// a test.
+func newFakeSlack(t *testing.T, failThreadTS string) *fakeSlack {
+	t.Helper()
+
+	fake := &fakeSlack{failThreadTS: failThreadTS}
+	srv := httptest.NewServer(fake)
+
+	previous := slackClient
+	slackClient = slack.New("xoxb-test", slack.OptionAPIURL(srv.URL+"/"))
+	t.Cleanup(func() {
+		slackClient = previous
+		srv.Close()
+	})
+	return fake
+}
+
+// addReminderTracker tracks a PR awaiting review by one reviewer, with its
+// message posted as messageTS.
+func addReminderTracker(t *testing.T, channelID, messageTS string, number int) {
+	t.Helper()
+
+	trackerID, err := db.CreateTracker(database, channelID, "U0CREATOR")
+	if err != nil {
+		t.Fatalf("CreateTracker: %v", err)
+	}
+	if err := db.UpdateTrackerMessageTS(database, trackerID, messageTS); err != nil {
+		t.Fatalf("UpdateTrackerMessageTS: %v", err)
+	}
+	prID, err := db.CreatePullRequest(database, trackerID, "octo", "app", number,
+		"https://github.com/octo/app/pull/1", db.ApprovalRequirement{Required: 1, Source: "default"})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	if err := db.CreateReviewer(database, prID, "U1"); err != nil {
+		t.Fatalf("CreateReviewer: %v", err)
+	}
+}
+
+func TestReminderSchedulerSurvivesRestartsAndFailures(t *testing.T) {
+	testDB := newTestDB(t)
+	fake := newFakeSlack(t, "1.1")
+
+	if _, err := testDB.Exec(
+		"INSERT INTO channel_reminders (slack_channel_id, interval_minutes) VALUES (?, ?)",
+		"C123", 60,
+	); err != nil {
+		t.Fatalf("insert reminder: %v", err)
+	}
+	// The first tracker's message is gone, so reminding it always fails
+	addReminderTracker(t, "C123", "1.1", 1)
+	addReminderTracker(t, "C123", "1.2", 2)
+
+	start := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+
+	newReminderScheduler(clock).tick()
+	if got := fake.posts(); len(got) != 2 {
+		t.Fatalf("first tick posted under %v, want both trackers", got)
+	}
+
+	// A restarted scheduler picks up last_fired_at rather than firing
+	// straight away, despite the failed tracker
+	now = start.Add(30 * time.Minute)
+	newReminderScheduler(clock).tick()
+	if got := fake.posts(); len(got) != 2 {
+		t.Fatalf("tick before the interval posted again: %v", got)
+	}
+
+	now = start.Add(time.Hour)
+	newReminderScheduler(clock).tick()
+	if got := fake.posts(); len(got) != 4 {
+		t.Fatalf("tick after the interval posted under %v, want both trackers again", got)
+	}
+
+	reminders, err := db.GetEnabledChannelReminders(testDB)
+	if err != nil {
+		t.Fatalf("GetEnabledChannelReminders: %v", err)
+	}
+	if len(reminders) != 1 || !reminders[0].LastFiredAt.Time.Equal(now) {
+		t.Errorf("last_fired_at = %v, want %v", reminders[0].LastFiredAt.Time, now)
+	}
+}
@@ -2,13 +2,16 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dylfrancis/revue/db"
 	"github.com/slack-go/slack"
@@ -19,20 +22,83 @@ var (
 	signingSecret       string
 	githubWebhookSecret string
 	database            *sql.DB
+
+	// slackWorkspaceURL is the workspace's base URL (e.g.
+	// "https://acme.slack.com/"), used to build message permalinks without
+	// an API call each. Empty if it couldn't be looked up.
+	slackWorkspaceURL string
 )
 
-func Start(port string, slackBotToken string, slackSigningSecret string, ghWebhookSecret string, db *sql.DB) error {
-	slackClient = slack.New(slackBotToken)
-	signingSecret = slackSigningSecret
-	githubWebhookSecret = ghWebhookSecret
+// Config holds everything Start needs to run the server.
+type Config struct {
+	Port                string
+	SlackBotToken       string
+	SlackSigningSecret  string
+	GitHubWebhookSecret string
+
+	// GitHubToken is an optional token for REST calls when Revue isn't
+	// configured as a GitHub App.
+	GitHubToken string
+
+	// GitHubAppID and GitHubAppPrivateKey (PEM) are optional. When both
+	// are set, REST calls use per-installation GitHub App tokens.
+	GitHubAppID         int64
+	GitHubAppPrivateKey []byte
+
+	// AutoLinkUsersByEmail links GitHub logins to Slack users with a
+	// matching email automatically, on top of /revue link.
+	AutoLinkUsersByEmail bool
+
+	// PollInterval switches GitHub ingestion from webhooks to polling each
+	// tracked PR on this interval. Zero means webhooks only.
+	PollInterval time.Duration
+}
+
+func Start(cfg Config, db *sql.DB) error {
+	slackClient = slack.New(cfg.SlackBotToken)
+	signingSecret = cfg.SlackSigningSecret
+	githubWebhookSecret = cfg.GitHubWebhookSecret
+	githubClient = newGitHubClient(cfg.GitHubToken)
+	autoLinkUsersByEmail = cfg.AutoLinkUsersByEmail
 	database = db
 
+	if auth, err := slackClient.AuthTest(); err != nil {
+		log.Printf("Failed to look up Slack workspace, tracker links will be plain text: %v", err)
+	} else {
+		slackWorkspaceURL = auth.URL
+	}
+
+	if cfg.GitHubAppID != 0 && len(cfg.GitHubAppPrivateKey) > 0 {
+		app, err := newGitHubApp(cfg.GitHubAppID, cfg.GitHubAppPrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to load GitHub App: %w", err)
+		}
+		ghApp = app
+		log.Printf("Authenticating to GitHub as App %d", cfg.GitHubAppID)
+	}
+
+	runJobWorkers(jobWorkers)
+	go newReminderScheduler(time.Now).run(reminderCheckInterval)
+	// Polling already re-reads every tracked PR, so it replaces reconciliation
+	if cfg.PollInterval > 0 {
+		log.Printf("Polling GitHub every %s", cfg.PollInterval)
+		go newPoller().run(cfg.PollInterval)
+	} else {
+		go runReconciler(reconcileInterval)
+	}
+
 	http.HandleFunc("/slack/commands", verifySlackRequest(handleSlashCommand))
 	http.HandleFunc("/slack/interactions", verifySlackRequest(handleInteraction))
-	http.HandleFunc("/github/webhooks", handleGitHubWebhook)
+	http.HandleFunc("/slack/events", verifySlackRequest(handleSlackEvent))
+	// go-github skips signature checks when the secret is empty, so without
+	// one (polling mode) the webhook endpoint isn't exposed at all
+	if cfg.GitHubWebhookSecret != "" {
+		http.HandleFunc("/github/webhooks", handleGitHubWebhook)
+		go runDeliveryCleanup(deliveryCleanupInterval)
+	}
 
-	log.Printf("Server started on port %s", port)
-	return http.ListenAndServe(fmt.Sprintf(":%s", port), nil)
+	log.Printf("Server started on port %s", cfg.Port)
+	return http.ListenAndServe(fmt.Sprintf(":%s", cfg.Port), nil)
 }
 
 // verifySlackRequest is middleware that verifies the request signature
@@ -94,8 +160,10 @@ func handleInteraction(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleBlockAction processes button clicks inside modals.
-// For the track modal, it handles "Add another PR" and "Remove last".
+// handleBlockAction processes button clicks inside modals and on tracker
+// messages. For the track modal, it handles "Add another PR", "Remove last"
+// and Enter in a URL field; tracker message buttons are handled in
+// tracker_actions.go.
 func handleBlockAction(w http.ResponseWriter, payload slack.InteractionCallback) {
 	if len(payload.ActionCallback.BlockActions) == 0 {
 		w.WriteHeader(http.StatusOK)
@@ -106,31 +174,17 @@ func handleBlockAction(w http.ResponseWriter, payload slack.InteractionCallback)
 
 	switch action.ActionID {
 	case "add_pr_url", "remove_pr_url":
-		// Count current URL fields by looking at block IDs in the existing view.
-		// Our URL blocks are named "pr_url_block_0", "pr_url_block_1", etc.
-		numURLFields := 0
-		for _, block := range payload.View.Blocks.BlockSet {
-			if strings.HasPrefix(block.ID(), "pr_url_block_") {
-				numURLFields++
-			}
-		}
-
+		numURLFields := countURLFields(payload.View)
 		if action.ActionID == "add_pr_url" {
 			numURLFields++
 		} else if numURLFields > 1 {
 			numURLFields--
 		}
 
-		// Rebuild the modal with the new field count
-		modal := slack.ModalViewRequest{
-			Type:            slack.VTModal,
-			CallbackID:      "track_pr",
-			Title:           slack.NewTextBlockObject("plain_text", "Track PRs", false, false),
-			Submit:          slack.NewTextBlockObject("plain_text", "Submit", false, false),
-			Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
-			PrivateMetadata: payload.View.PrivateMetadata,
-			Blocks:          buildTrackModalBlocks(numURLFields),
-		}
+		// Rebuild the modal with the new field count, keeping the reviewers
+		// block ID so Slack keeps the selected reviewers
+		blocks := buildTrackModalBlocks(numURLFields, reviewersBlockOf(payload.View), nil)
+		modal := trackModalRequest(payload.View.PrivateMetadata, blocks)
 
 		// UpdateView replaces the current modal content in-place.
 		// We pass the view ID so Slack knows which modal to update.
@@ -138,16 +192,117 @@ func handleBlockAction(w http.ResponseWriter, payload slack.InteractionCallback)
 		if err != nil {
 			log.Printf("Failed to update view: %v", err)
 		}
+	case actionTrackerReviewing:
+		handleTrackerReviewing(payload, action)
+	case actionTrackerSnooze:
+		handleTrackerSnooze(payload, action)
+	case actionTrackerAddPR:
+		handleTrackerAddPR(payload, action)
+	case actionTrackerUntrack:
+		handleTrackerUntrack(payload, action)
+	default:
+		if payload.View.CallbackID == "track_pr" && strings.HasPrefix(action.ActionID, "pr_url_") {
+			// Suggesting reviewers takes several GitHub calls, more than
+			// Slack waits for an acknowledgement
+			go suggestTrackModalReviewers(payload)
+		}
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// suggestTrackModalReviewers adds the code owners of the PRs entered so
+// far in the track modal to its reviewers, keeping anyone already picked.
+func suggestTrackModalReviewers(payload slack.InteractionCallback) {
+	values := payload.View.State.Values
+
+	var prs []parsedPR
+	for i := range countURLFields(payload.View) {
+		raw := values[fmt.Sprintf("pr_url_block_%d", i)][fmt.Sprintf("pr_url_%d", i)].Value
+		if pr, err := parsePRURL(raw); err == nil {
+			prs = append(prs, pr)
+		}
+	}
+	if len(prs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reviewerSuggestionTimeout)
+	defer cancel()
+
+	suggested, err := suggestReviewers(ctx, prs)
+	if err != nil {
+		log.Printf("Failed to suggest reviewers: %v", err)
+		return
+	}
+
+	reviewers := selectedReviewers(values)
+	picked := make(map[string]bool)
+	for _, uid := range reviewers {
+		picked[uid] = true
+	}
+	for _, uid := range suggested {
+		if !picked[uid] {
+			reviewers = append(reviewers, uid)
+		}
+	}
+	if len(reviewers) == len(picked) {
+		return // nothing new to suggest
+	}
+
+	// A fresh block ID makes Slack apply initial_users instead of keeping
+	// the select's current state
+	blockID := fmt.Sprintf("%s_%d", reviewersBlockPrefix, time.Now().UnixNano())
+	blocks := buildTrackModalBlocks(countURLFields(payload.View), blockID, reviewers)
+	modal := trackModalRequest(payload.View.PrivateMetadata, blocks)
+
+	// The hash makes Slack reject the update if the modal changed meanwhile
+	if _, err := slackClient.UpdateView(modal, "", payload.View.Hash, payload.View.ID); err != nil {
+		log.Printf("Failed to update view with suggested reviewers: %v", err)
+	}
+}
+
+// countURLFields counts the PR URL fields in the track modal by their
+// block IDs: "pr_url_block_0", "pr_url_block_1", etc.
+func countURLFields(view slack.View) int {
+	n := 0
+	for _, block := range view.Blocks.BlockSet {
+		if strings.HasPrefix(block.ID(), "pr_url_block_") {
+			n++
+		}
+	}
+	return n
+}
+
+// reviewersBlockOf returns the block ID of the track modal's reviewers
+// select.
+func reviewersBlockOf(view slack.View) string {
+	for _, block := range view.Blocks.BlockSet {
+		if strings.HasPrefix(block.ID(), reviewersBlockPrefix) {
+			return block.ID()
+		}
+	}
+	return reviewersBlockPrefix
+}
+
+// selectedReviewers returns the users picked in the track modal's
+// reviewers select, whatever its current block ID.
+func selectedReviewers(values map[string]map[string]slack.BlockAction) []string {
+	for blockID, actions := range values {
+		if strings.HasPrefix(blockID, reviewersBlockPrefix) {
+			return actions["reviewers"].SelectedUsers
+		}
+	}
+	return nil
+}
+
 // handleViewSubmission processes modal form submissions.
 func handleViewSubmission(w http.ResponseWriter, payload slack.InteractionCallback) {
 	switch payload.View.CallbackID {
 	case "track_pr":
 		handleTrackPRSubmission(w, payload)
+	case "add_pr":
+		handleAddPRSubmission(w, payload)
 	default:
 		log.Printf("Unhandled view submission callback: %s", payload.View.CallbackID)
 		w.WriteHeader(http.StatusOK)
@@ -155,62 +310,34 @@ func handleViewSubmission(w http.ResponseWriter, payload slack.InteractionCallba
 }
 
 // handleTrackPRSubmission processes the "Track PRs" modal submission.
-// It parses PR URLs, saves everything to the database, and posts a
-// summary message to the Slack channel.
+// It parses PR URLs and approval thresholds, checks on GitHub that each PR
+// exists and is open, saves everything to the database, and queues the
+// tracker's message for posting to the Slack channel.
 func handleTrackPRSubmission(w http.ResponseWriter, payload slack.InteractionCallback) {
 	channelID := payload.View.PrivateMetadata
 	values := payload.View.State.Values
 
-	// Extract PR URLs from the dynamic input fields.
-	// Each field has block_id "pr_url_block_0", "pr_url_block_1", etc.
-	// and action_id "pr_url_0", "pr_url_1", etc.
-	var prs []parsedPR
-	for i := 0; ; i++ {
-		blockID := fmt.Sprintf("pr_url_block_%d", i)
-		actionID := fmt.Sprintf("pr_url_%d", i)
-
-		block, ok := values[blockID]
-		if !ok {
-			break // no more URL fields
-		}
+	prs, fieldErrors := readPRURLFields(values)
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
+		return
+	}
 
-		raw := block[actionID].Value
-		pr, err := parsePRURL(raw)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			err := json.NewEncoder(w).Encode(map[string]interface{}{
-				"response_action": "errors",
-				"errors": map[string]string{
-					blockID: err.Error(),
-				},
-			})
-			if err != nil {
-				log.Printf("Failed to encode JSON response: %v", err)
-				return
-			}
-			return
-		}
-		prs = append(prs, pr)
+	approvals, fieldErrors := readApprovalsFields(values, len(prs))
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
+		return
 	}
 
-	if len(prs) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"response_action": "errors",
-			"errors": map[string]string{
-				"pr_url_block_0": "At least one PR URL is required",
-			},
-		})
-		if err != nil {
-			log.Printf("Failed to encode JSON response: %v", err)
-			return
-		}
+	metas, fieldErrors := validateTrackablePRs(prs)
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
 		return
 	}
 
-	reviewerIDs := values["reviewers_block"]["reviewers"].SelectedUsers
+	reviewerIDs := selectedReviewers(values)
 
-	trackerID, err := db.CreateTracker(database, channelID)
+	trackerID, err := db.CreateTracker(database, channelID, payload.User.ID)
 	if err != nil {
 		log.Printf("Failed to create tracker: %v", err)
 		http.Error(w, "Internal error", http.StatusInternalServerError)
@@ -218,13 +345,28 @@ func handleTrackPRSubmission(w http.ResponseWriter, payload slack.InteractionCal
 	}
 
 	// Insert each PR and link all reviewers to it
-	for _, pr := range prs {
-		prID, err := db.CreatePullRequest(database, trackerID, pr.Owner, pr.Repo, pr.Number, pr.URL)
+	for i, pr := range prs {
+		prID, err := db.CreatePullRequest(database, trackerID, pr.Owner, pr.Repo, pr.Number, pr.URL, approvals[i])
 		if err != nil {
 			log.Printf("Failed to create pull request: %v", err)
 			http.Error(w, "Internal error", http.StatusInternalServerError)
 			return
 		}
+		if err := db.UpdatePullRequestMetadata(database, prID, metas[i]); err != nil {
+			log.Printf("Failed to store pull request metadata: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if approvals[i].Source != "manual" {
+			if err := enqueueJob(jobApprovalRequirement, prID, "", ""); err != nil {
+				log.Printf("Failed to queue approval requirement lookup: %v", err)
+			}
+		}
+		// CI that finished before the PR was tracked never reaches us as
+		// a webhook we'd match, so read what's already reported
+		if err := enqueueJob(jobCIStatus, prID, "", commitPartitionKey(pr.Owner, pr.Repo, metas[i].HeadSHA)); err != nil {
+			log.Printf("Failed to queue CI status lookup: %v", err)
+		}
 		for _, reviewerID := range reviewerIDs {
 			if err := db.CreateReviewer(database, prID, reviewerID); err != nil {
 				log.Printf("Failed to create reviewer: %v", err)
@@ -234,47 +376,138 @@ func handleTrackPRSubmission(w http.ResponseWriter, payload slack.InteractionCal
 		}
 	}
 
-	messageTS, err := postTrackerMessage(channelID, prs, reviewerIDs)
-	if err != nil {
-		log.Printf("Failed to post tracker message: %v", err)
-		// DB rows created but message failed — still close the modal
-		w.WriteHeader(http.StatusOK)
+	// Post from the queue and close the modal now; GitHub has already used
+	// most of the time Slack gives us to answer, and a failed post is
+	// retried rather than leaving the tracker without a message
+	if err := enqueueTrackerPost(trackerID); err != nil {
+		log.Printf("Failed to queue tracker post: %v", err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
-	// Save the message timestamp so we can update this message later
+	w.WriteHeader(http.StatusOK)
+}
+
+// postNewTracker posts a newly created tracker's message and saves its
+// timestamp so the message can be updated later. It does nothing if the
+// message has already been posted.
+func postNewTracker(trackerID int64) error {
+	tracker, err := db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		return fmt.Errorf("failed to get tracker: %w", err)
+	}
+	if tracker.SlackMessageTS != "" {
+		return nil
+	}
+
+	messageTS, err := postTrackerMessage(trackerID)
+	if err != nil {
+		return err
+	}
+
 	if err := db.UpdateTrackerMessageTS(database, trackerID, messageTS); err != nil {
-		log.Printf("Failed to update tracker message TS: %v", err)
+		return fmt.Errorf("failed to update tracker message TS: %w", err)
 	}
+	return nil
+}
 
-	w.WriteHeader(http.StatusOK)
+// abandonTrackerPost untracks a tracker whose message could never be
+// posted and tells its creator, so they can fix the channel and track
+// the PRs again.
+func abandonTrackerPost(trackerID int64) {
+	tracker, err := db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		log.Printf("Failed to get tracker %d: %v", trackerID, err)
+		return
+	}
+
+	if err := db.UntrackTracker(database, trackerID); err != nil {
+		log.Printf("Failed to untrack tracker %d: %v", trackerID, err)
+	} else {
+		forgetTrackerCIChecks(trackerID)
+	}
+
+	text := fmt.Sprintf("Revue couldn't post your PR tracker in <#%s>, so those PRs aren't being tracked. "+
+		"Check that Revue has been added to the channel, then track them again.", tracker.SlackChannelID)
+	if _, _, err := slackClient.PostMessage(tracker.CreatedBySlackUserID, slack.MsgOptionText(text, false)); err != nil {
+		log.Printf("Failed to tell %s their tracker wasn't posted: %v", tracker.CreatedBySlackUserID, err)
+	}
 }
 
-// postTrackerMessage sends a summary of tracked PRs to the Slack channel
-// and returns the message timestamp (used to update the message later).
-func postTrackerMessage(channelID string, prs []parsedPR, reviewerIDs []string) (string, error) {
-	var lines []string
-	lines = append(lines, "*PR Tracker*\n")
-	for _, pr := range prs {
-		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — :white_circle: awaiting review",
-			pr.URL, pr.Owner, pr.Repo, pr.Number))
+// readPRURLFields extracts and parses PR URLs from the dynamic input fields.
+// Each field has block_id "pr_url_block_0", "pr_url_block_1", etc. and
+// action_id "pr_url_0", "pr_url_1", etc. Any problems are returned as
+// modal field errors keyed by block ID.
+func readPRURLFields(values map[string]map[string]slack.BlockAction) ([]parsedPR, map[string]string) {
+	var prs []parsedPR
+	for i := 0; ; i++ {
+		blockID := fmt.Sprintf("pr_url_block_%d", i)
+		actionID := fmt.Sprintf("pr_url_%d", i)
+
+		block, ok := values[blockID]
+		if !ok {
+			break // no more URL fields
+		}
+
+		raw := block[actionID].Value
+		pr, err := parsePRURL(raw)
+		if err != nil {
+			return nil, map[string]string{blockID: err.Error()}
+		}
+		prs = append(prs, pr)
 	}
 
-	var mentions []string
-	for _, uid := range reviewerIDs {
-		mentions = append(mentions, fmt.Sprintf("<@%s>", uid))
+	if len(prs) == 0 {
+		return nil, map[string]string{"pr_url_block_0": "At least one PR URL is required"}
 	}
-	lines = append(lines, "\nReviewers: "+strings.Join(mentions, " "))
 
-	text := strings.Join(lines, "\n")
+	return prs, nil
+}
 
-	_, ts, err := slackClient.PostMessage(
-		channelID,
-		slack.MsgOptionText(text, false),
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to post message: %w", err)
+// readApprovalsFields reads how many approvals each of numPRs PRs needs:
+// the PR's own "pr_approvals_block_<i>" field if filled in, otherwise the
+// tracker-wide "approvals_block" field. PRs with neither get a "default"
+// requirement, to be replaced by their base branch's protection rules
+// (see refreshApprovalRequirement). Values below 1 are returned as modal
+// field errors keyed by block ID.
+func readApprovalsFields(values map[string]map[string]slack.BlockAction, numPRs int) ([]db.ApprovalRequirement, map[string]string) {
+	trackerDefault := db.ApprovalRequirement{Required: 1, Source: "default"}
+	if raw := strings.TrimSpace(values["approvals_block"]["approvals"].Value); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return nil, map[string]string{"approvals_block": "Enter a whole number of at least 1, or leave blank to follow GitHub"}
+		}
+		trackerDefault = db.ApprovalRequirement{Required: n, Source: "manual"}
+	}
+
+	approvals := make([]db.ApprovalRequirement, numPRs)
+	for i := range approvals {
+		approvals[i] = trackerDefault
+
+		blockID := fmt.Sprintf("pr_approvals_block_%d", i)
+		raw := strings.TrimSpace(values[blockID][fmt.Sprintf("pr_approvals_%d", i)].Value)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			return nil, map[string]string{blockID: "Enter a whole number of at least 1, or leave blank for the default"}
+		}
+		approvals[i] = db.ApprovalRequirement{Required: n, Source: "manual"}
 	}
 
-	return ts, nil
+	return approvals, nil
+}
+
+// respondViewErrors rejects a modal submission, showing each error under
+// the input block with the matching block ID.
+func respondViewErrors(w http.ResponseWriter, fieldErrors map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"response_action": "errors",
+		"errors":          fieldErrors,
+	})
+	if err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
 }
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+// newTestDB points the server at a migrated database in a temporary
+// directory for the duration of a test. Migrations are read relative to
+// the repo root, so the test runs from there.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Chdir("..")
+	testDB, err := db.Connect(filepath.Join(dir, "revue.db"))
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+
+	previous := database
+	database = testDB
+	t.Cleanup(func() {
+		database = previous
+		_ = testDB.Close()
+	})
+	return testDB
+}
+
+// trackTestPR tracks a single PR in a new tracker and returns the PR's ID.
+func trackTestPR(t *testing.T, owner, repo string, number int) int64 {
+	t.Helper()
+
+	trackerID, err := db.CreateTracker(database, "C123", "U123")
+	if err != nil {
+		t.Fatalf("CreateTracker: %v", err)
+	}
+	prID, err := db.CreatePullRequest(database, trackerID, owner, repo, number,
+		"https://github.com/"+owner+"/"+repo+"/pull/1", db.ApprovalRequirement{Required: 1, Source: "default"})
+	if err != nil {
+		t.Fatalf("CreatePullRequest: %v", err)
+	}
+	return prID
+}
@@ -1,15 +1,85 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/dylfrancis/revue/db"
 	"github.com/slack-go/slack"
 )
 
+// slashCommand holds the parts of a /revue invocation that subcommands use.
+type slashCommand struct {
+	Command   string // e.g. "/revue"
+	UserID    string
+	ChannelID string
+	TriggerID string
+	Args      []string // words after the subcommand name
+}
+
+// subcommand describes one /revue subcommand. NumArgs is the exact number
+// of arguments it accepts; anything else replies with its usage.
+type subcommand struct {
+	Name        string
+	Usage       string
+	Description string
+	NumArgs     int
+	Handler     func(w http.ResponseWriter, cmd slashCommand)
+}
+
+// subcommands lists every /revue subcommand in the order shown by help.
+// It's filled in by init because handleHelpCommand refers back to it.
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{
+			Name:        "track",
+			Usage:       "track",
+			Description: "Open a form to track one or more PRs in this channel",
+			Handler:     handleTrackCommand,
+		},
+		{
+			Name:        "list",
+			Usage:       "list",
+			Description: "Show every active tracker in this channel",
+			Handler: func(w http.ResponseWriter, cmd slashCommand) {
+				handleListCommand(w, cmd.ChannelID)
+			},
+		},
+		{
+			Name:        "mine",
+			Usage:       "mine",
+			Description: "Show every PR waiting on your review, across all channels",
+			Handler:     handleMineCommand,
+		},
+		{
+			Name:        "link",
+			Usage:       "link <github-login>",
+			Description: "Link your Slack account to your GitHub login so your reviews are attributed to you",
+			NumArgs:     1,
+			Handler:     handleLinkCommand,
+		},
+		{
+			Name:        "stale-approvals",
+			Usage:       "stale-approvals <on|off> <owner/repo|channel>",
+			Description: "Choose whether new commits reset approvals on a repo's PRs, or on every PR tracked in this channel",
+			NumArgs:     2,
+			Handler:     handleStaleApprovalsCommand,
+		},
+		{
+			Name:        "help",
+			Usage:       "help",
+			Description: "Show this list of commands",
+			Handler:     handleHelpCommand,
+		},
+	}
+}
+
 func handleSlashCommand(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Bad request", http.StatusBadRequest)
@@ -18,27 +88,234 @@ func handleSlashCommand(w http.ResponseWriter, r *http.Request) {
 
 	command := r.FormValue("command")
 	text := r.FormValue("text")
-	triggerID := r.FormValue("trigger_id")
-	channelID := r.FormValue("channel_id")
 
 	log.Printf("Received command: %s %s", command, text)
 
-	if text == "track" {
-		if err := openTrackModal(triggerID, channelID); err != nil {
-			log.Printf("Error opening modal: %v", err)
-			http.Error(w, "Failed to open modal", http.StatusInternalServerError)
+	// "/revue" on its own is treated as "/revue help"
+	fields := strings.Fields(text)
+	name := "help"
+	if len(fields) > 0 {
+		name = strings.ToLower(fields[0])
+		fields = fields[1:]
+	}
+
+	cmd := slashCommand{
+		Command:   command,
+		UserID:    r.FormValue("user_id"),
+		ChannelID: r.FormValue("channel_id"),
+		TriggerID: r.FormValue("trigger_id"),
+		Args:      fields,
+	}
+
+	for _, sub := range subcommands {
+		if sub.Name != name {
+			continue
+		}
+		if len(cmd.Args) != sub.NumArgs {
+			respondEphemeral(w, fmt.Sprintf("Usage: `%s %s`", command, sub.Usage))
 			return
 		}
+		sub.Handler(w, cmd)
+		return
+	}
+
+	respondEphemeral(w, fmt.Sprintf("Unknown command `%s`. Try `%s help` to see what I can do.", name, command))
+}
+
+// handleTrackCommand opens the "Track PRs" modal.
+func handleTrackCommand(w http.ResponseWriter, cmd slashCommand) {
+	if err := openTrackModal(cmd.TriggerID, cmd.ChannelID); err != nil {
+		log.Printf("Error opening modal: %v", err)
+		http.Error(w, "Failed to open modal", http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleHelpCommand replies with every subcommand and its usage.
+func handleHelpCommand(w http.ResponseWriter, cmd slashCommand) {
+	var lines []string
+	lines = append(lines, "*Revue commands*")
+	for _, sub := range subcommands {
+		lines = append(lines, fmt.Sprintf("• `%s %s` — %s", cmd.Command, sub.Usage, sub.Description))
+	}
+
+	respondEphemeral(w, strings.Join(lines, "\n"))
+}
+
+// handleListCommand replies (visible only to the caller) with every active
+// tracker in the channel, its PR statuses and a link to the tracker message.
+func handleListCommand(w http.ResponseWriter, channelID string) {
+	trackers, err := db.GetActiveTrackersByChannel(database, channelID)
+	if err != nil {
+		log.Printf("Failed to get trackers for channel %s: %v", channelID, err)
+		respondEphemeral(w, "Sorry, something went wrong fetching trackers.")
+		return
+	}
+
+	if len(trackers) == 0 {
+		respondEphemeral(w, "No active trackers in this channel.")
+		return
+	}
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("*Active trackers in this channel (%d)*", len(trackers)))
+	for _, tracker := range trackers {
+		lines = append(lines, "\n*"+trackerLink(tracker)+"*")
+
+		prs, err := db.GetPullRequestsByTracker(database, tracker.ID)
+		if err != nil {
+			log.Printf("Failed to get PRs for tracker %d: %v", tracker.ID, err)
+			respondEphemeral(w, "Sorry, something went wrong fetching trackers.")
+			return
+		}
+		for _, pr := range prs {
+			lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — %s %s",
+				pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+				statusEmoji(displayStatus(pr)), statusLabel(displayStatus(pr))))
+		}
+	}
+
+	respondEphemeral(w, strings.Join(lines, "\n"))
+}
+
+// handleMineCommand replies with every open PR the caller is a reviewer
+// of, oldest first, with how long it has been waiting.
+func handleMineCommand(w http.ResponseWriter, cmd slashCommand) {
+	prs, err := db.GetPendingReviewsForUser(database, cmd.UserID)
+	if err != nil {
+		log.Printf("Failed to get pending reviews for %s: %v", cmd.UserID, err)
+		respondEphemeral(w, "Sorry, something went wrong fetching your reviews.")
+		return
+	}
+
+	if len(prs) == 0 {
+		respondEphemeral(w, "Nothing is waiting on your review. :tada:")
+		return
+	}
+
+	// Several PRs usually share a tracker, so only build each link once
+	links := make(map[int64]string)
+
+	var lines []string
+	lines = append(lines, fmt.Sprintf("*Waiting on your review (%d)*", len(prs)))
+	for _, pr := range prs {
+		link, ok := links[pr.TrackerID]
+		if !ok {
+			tracker, err := db.GetTrackerByID(database, pr.TrackerID)
+			if err != nil {
+				log.Printf("Failed to get tracker %d: %v", pr.TrackerID, err)
+				respondEphemeral(w, "Sorry, something went wrong fetching your reviews.")
+				return
+			}
+			link = trackerLink(*tracker)
+			links[pr.TrackerID] = link
+		}
+
+		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — waiting %s (%s)",
+			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+			formatAge(time.Since(pr.CreatedAt)), link))
+	}
+
+	respondEphemeral(w, strings.Join(lines, "\n"))
+}
+
+// handleStaleApprovalsCommand opts a repo, or the whole channel, in to or
+// out of resetting approvals when new commits are pushed to a PR.
+func handleStaleApprovalsCommand(w http.ResponseWriter, cmd slashCommand) {
+	var enabled bool
+	switch strings.ToLower(cmd.Args[0]) {
+	case "on":
+		enabled = true
+	case "off":
+	default:
+		respondEphemeral(w, fmt.Sprintf("Say `on` or `off`, e.g. `%s stale-approvals on owner/repo`.", cmd.Command))
+		return
+	}
+
+	var channelID, owner, repo, scope string
+	if strings.EqualFold(cmd.Args[1], "channel") {
+		channelID = cmd.ChannelID
+		scope = "PRs tracked in this channel"
+	} else {
+		var ok bool
+		owner, repo, ok = strings.Cut(cmd.Args[1], "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			respondEphemeral(w, fmt.Sprintf("`%s` isn't a repo. Give it as `owner/repo`, or say `channel` for every PR tracked here.", cmd.Args[1]))
+			return
+		}
+		scope = fmt.Sprintf("`%s/%s` PRs", owner, repo)
+	}
+
+	if err := db.SetStaleApprovalPolicy(database, channelID, owner, repo, enabled); err != nil {
+		log.Printf("Failed to set stale approval policy for %q: %v", cmd.Args[1], err)
+		respondEphemeral(w, "Sorry, something went wrong saving that setting.")
+		return
+	}
+
+	if enabled {
+		respondEphemeral(w, fmt.Sprintf("New commits will now reset approvals on %s.", scope))
+	} else {
+		respondEphemeral(w, fmt.Sprintf("New commits will no longer reset approvals on %s.", scope))
+	}
+}
+
+// trackerLink returns mrkdwn linking to a tracker's Slack message, falling
+// back to plain text if the message was never posted or the workspace URL
+// is unknown. The permalink is built locally, as chat.getPermalink for
+// every tracker would be too slow for a slash command's response.
+func trackerLink(tracker db.Tracker) string {
+	label := fmt.Sprintf("Tracker #%d", tracker.ID)
+	if tracker.SlackMessageTS == "" || slackWorkspaceURL == "" {
+		return label
+	}
+
+	permalink := fmt.Sprintf("%s/archives/%s/p%s",
+		strings.TrimSuffix(slackWorkspaceURL, "/"), tracker.SlackChannelID,
+		strings.Replace(tracker.SlackMessageTS, ".", "", 1))
+	return fmt.Sprintf("<%s|%s>", permalink, label)
+}
+
+// formatAge renders a duration as a short, human-friendly age such as
+// "3d 4h", "5h 12m" or "8m".
+func formatAge(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+// respondEphemeral answers a slash command with a message only the
+// invoking user can see.
+func respondEphemeral(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"response_type": "ephemeral",
+		"text":          text,
+	})
+	if err != nil {
+		log.Printf("Failed to encode JSON response: %v", err)
+	}
+}
+
 // buildTrackModalBlocks builds the Block Kit blocks for the track modal.
-// numURLFields controls how many PR URL input fields to show.
+// numURLFields controls how many PR URL input fields to show; each has an
+// optional approvals override below it.
 // This is called both when opening the modal (with 1 field) and when
-// updating it after the user clicks "Add another PR".
-func buildTrackModalBlocks(numURLFields int) slack.Blocks {
+// updating it after the user clicks "Add another PR" or enters a URL.
+// Slack keeps what the user picked in the reviewers select across updates
+// as long as its block ID stays the same, and only applies
+// initialReviewers when the block ID is new (see reviewersBlockPrefix).
+func buildTrackModalBlocks(numURLFields int, reviewersBlockID string, initialReviewers []string) slack.Blocks {
 	var blocks []slack.Block
 
 	// One input block per URL field
@@ -48,10 +325,16 @@ func buildTrackModalBlocks(numURLFields int) slack.Blocks {
 			fmt.Sprintf("pr_url_%d", i),
 		)
 
+		// Pressing Enter suggests reviewers from the PR's CODEOWNERS
+		urlInput.DispatchActionConfig = &slack.DispatchActionConfig{
+			TriggerActionsOn: []string{"on_enter_pressed"},
+		}
+
 		blockID := fmt.Sprintf("pr_url_block_%d", i)
 		label := slack.NewTextBlockObject("plain_text", fmt.Sprintf("PR URL #%d", i+1), false, false)
-		inputBlock := slack.NewInputBlock(blockID, label, nil, urlInput)
-		blocks = append(blocks, inputBlock)
+		hint := slack.NewTextBlockObject("plain_text", "Press Enter to suggest reviewers from CODEOWNERS", false, false)
+		inputBlock := slack.NewInputBlock(blockID, label, hint, urlInput).WithDispatchAction(true)
+		blocks = append(blocks, inputBlock, prApprovalsInputBlock(i))
 	}
 
 	// Action block with Add / Remove buttons
@@ -67,14 +350,33 @@ func buildTrackModalBlocks(numURLFields int) slack.Blocks {
 
 	blocks = append(blocks, slack.NewActionBlock("pr_url_actions", actionElements...))
 
+	// Tracker-wide approvals threshold, overridable per PR above. Left
+	// blank, each PR follows its base branch's protection rules.
+	approvalsInput := slack.NewNumberInputBlockElement(
+		slack.NewTextBlockObject("plain_text", "From GitHub", false, false),
+		"approvals",
+		false,
+	).WithMinValue("1")
+	approvalsBlock := slack.NewInputBlock(
+		"approvals_block",
+		slack.NewTextBlockObject("plain_text", "Approvals required", false, false),
+		slack.NewTextBlockObject("plain_text", "Applies to every PR without its own number. Leave blank to use the base branch's protection rules.", false, false),
+		approvalsInput,
+	)
+	approvalsBlock.Optional = true
+	blocks = append(blocks, approvalsBlock)
+
 	// Reviewers multi-user select
 	reviewerSelect := slack.NewOptionsMultiSelectBlockElement(
 		slack.MultiOptTypeUser,
 		slack.NewTextBlockObject("plain_text", "Select reviewers", false, false),
 		"reviewers",
 	)
+	if len(initialReviewers) > 0 {
+		reviewerSelect.WithInitialUsers(initialReviewers...)
+	}
 	reviewerBlock := slack.NewInputBlock(
-		"reviewers_block",
+		reviewersBlockID,
 		slack.NewTextBlockObject("plain_text", "Reviewers", false, false),
 		nil,
 		reviewerSelect,
@@ -84,17 +386,50 @@ func buildTrackModalBlocks(numURLFields int) slack.Blocks {
 	return slack.Blocks{BlockSet: blocks}
 }
 
-// openTrackModal opens the "Track PRs" modal with 1 URL field to start.
-func openTrackModal(triggerID string, channelID string) error {
-	modal := slack.ModalViewRequest{
+// prApprovalsInputBlock builds the optional input overriding how many
+// approvals the PR in URL field i needs. Its block ID is
+// "pr_approvals_block_<i>" and its action ID "pr_approvals_<i>".
+func prApprovalsInputBlock(i int) *slack.InputBlock {
+	input := slack.NewNumberInputBlockElement(
+		slack.NewTextBlockObject("plain_text", "Default", false, false),
+		fmt.Sprintf("pr_approvals_%d", i),
+		false,
+	).WithMinValue("1")
+
+	block := slack.NewInputBlock(
+		fmt.Sprintf("pr_approvals_block_%d", i),
+		slack.NewTextBlockObject("plain_text", "Approvals required for this PR", false, false),
+		nil,
+		input,
+	)
+	block.Optional = true
+	return block
+}
+
+// reviewersBlockPrefix is the block ID of the track modal's reviewers
+// select, possibly followed by a suffix when reviewers are suggested.
+const reviewersBlockPrefix = "reviewers_block"
+
+// reviewerSuggestionTimeout bounds the GitHub and Slack calls made to
+// suggest reviewers from CODEOWNERS.
+const reviewerSuggestionTimeout = 15 * time.Second
+
+// trackModalRequest builds the "Track PRs" modal for a channel.
+func trackModalRequest(channelID string, blocks slack.Blocks) slack.ModalViewRequest {
+	return slack.ModalViewRequest{
 		Type:            slack.VTModal,
 		CallbackID:      "track_pr",
 		Title:           slack.NewTextBlockObject("plain_text", "Track PRs", false, false),
 		Submit:          slack.NewTextBlockObject("plain_text", "Submit", false, false),
 		Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
 		PrivateMetadata: channelID,
-		Blocks:          buildTrackModalBlocks(1),
+		Blocks:          blocks,
 	}
+}
+
+// openTrackModal opens the "Track PRs" modal with 1 URL field to start.
+func openTrackModal(triggerID string, channelID string) error {
+	modal := trackModalRequest(channelID, buildTrackModalBlocks(1, reviewersBlockPrefix, nil))
 
 	_, err := slackClient.OpenView(triggerID, modal)
 	if err != nil {
@@ -104,11 +439,24 @@ func openTrackModal(triggerID string, channelID string) error {
 	return nil
 }
 
+// displayStatus returns the status shown for a PR in the tracker message.
+// Drafts that are still in review show as "draft" regardless of approvals.
+func displayStatus(pr db.PullRequest) string {
+	if pr.IsDraft && pr.Status != "merged" && pr.Status != "closed" {
+		return "draft"
+	}
+	return pr.Status
+}
+
 // statusEmoji maps a PR status to its display emoji.
 func statusEmoji(status string) string {
 	switch status {
+	case "draft":
+		return ":construction:"
 	case "approved":
 		return ":white_check_mark:"
+	case "changes_requested":
+		return ":no_entry:"
 	case "merged":
 		return ":large_green_circle:"
 	case "closed":
@@ -121,8 +469,12 @@ func statusEmoji(status string) string {
 // statusLabel maps a PR status to a human-readable label.
 func statusLabel(status string) string {
 	switch status {
+	case "draft":
+		return "draft"
 	case "approved":
 		return "approved"
+	case "changes_requested":
+		return "changes requested"
 	case "merged":
 		return "merged"
 	case "closed":
@@ -132,65 +484,17 @@ func statusLabel(status string) string {
 	}
 }
 
-// updateTrackerMessage fetches the current state of a tracker from the DB
-// and updates the Slack message with the latest PR statuses.
-func updateTrackerMessage(trackerID int64) error {
-	tracker, err := db.GetTrackerByID(database, trackerID)
-	if err != nil {
-		return fmt.Errorf("failed to get tracker: %w", err)
-	}
-
-	prs, err := db.GetPullRequestsByTracker(database, trackerID)
-	if err != nil {
-		return fmt.Errorf("failed to get PRs: %w", err)
-	}
-
-	// Collect all unique reviewers across all PRs
-	reviewerSet := make(map[string]bool)
-	for _, pr := range prs {
-		reviewers, err := db.GetReviewersByPR(database, pr.ID)
-		if err != nil {
-			return fmt.Errorf("failed to get reviewers: %w", err)
-		}
-		for _, uid := range reviewers {
-			reviewerSet[uid] = true
-		}
-	}
-
-	// Build the message
-	title := "*PR Tracker*"
-	if tracker.Status == "completed" {
-		title = "*PR Tracker* — :tada: All done!"
-	}
-
-	var lines []string
-	lines = append(lines, title+"\n")
-	for _, pr := range prs {
-		approvalInfo := ""
-		if pr.Status == "open" || pr.Status == "approved" {
-			approvalInfo = fmt.Sprintf(" (%d/%d approvals)", pr.ApprovalsCurrent, pr.ApprovalsRequired)
-		}
-		lines = append(lines, fmt.Sprintf("• <%s|%s/%s#%d> — %s %s%s",
-			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
-			statusEmoji(pr.Status), statusLabel(pr.Status), approvalInfo))
-	}
-
-	var mentions []string
-	for uid := range reviewerSet {
-		mentions = append(mentions, fmt.Sprintf("<@%s>", uid))
-	}
-	lines = append(lines, "\nReviewers: "+strings.Join(mentions, " "))
-
-	text := strings.Join(lines, "\n")
-
-	_, _, _, err = slackClient.UpdateMessage(
-		tracker.SlackChannelID,
-		tracker.SlackMessageTS,
-		slack.MsgOptionText(text, false),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update message: %w", err)
+// ciIndicator maps a rolled-up CI status to its display emoji.
+// Returns an empty string when no checks have been reported.
+func ciIndicator(status string) string {
+	switch status {
+	case "success":
+		return ":heavy_check_mark:"
+	case "failure":
+		return ":x:"
+	case "pending":
+		return ":hourglass_flowing_sand:"
+	default:
+		return ""
 	}
-
-	return nil
 }
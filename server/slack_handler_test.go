@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dylfrancis/revue/db"
+)
+
+func TestTrackerLink(t *testing.T) {
+	previous := slackWorkspaceURL
+	t.Cleanup(func() { slackWorkspaceURL = previous })
+
+	posted := db.Tracker{ID: 7, SlackChannelID: "C0123", SlackMessageTS: "1700000000.000100"}
+	unposted := db.Tracker{ID: 8, SlackChannelID: "C0123"}
+
+	tests := []struct {
+		name         string
+		workspaceURL string
+		tracker      db.Tracker
+		want         string
+	}{
+		{"posted", "https://acme.slack.com/", posted, "<https://acme.slack.com/archives/C0123/p1700000000000100|Tracker #7>"},
+		{"never posted", "https://acme.slack.com/", unposted, "Tracker #8"},
+		{"workspace unknown", "", posted, "Tracker #7"},
+	}
+
+	for _, tt := range tests {
+		slackWorkspaceURL = tt.workspaceURL
+		if got := trackerLink(tt.tracker); got != tt.want {
+			t.Errorf("%s: trackerLink = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStaleApprovalsCommand(t *testing.T) {
+	newTestDB(t)
+
+	run := func(text string) string {
+		t.Helper()
+		form := url.Values{"command": {"/revue"}, "text": {text}, "user_id": {"U1"}, "channel_id": {"C1"}}
+		req := httptest.NewRequest(http.MethodPost, "/slack/commands", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handleSlashCommand(rec, req)
+
+		var resp struct{ Text string }
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return resp.Text
+	}
+	enabled := func(channelID, owner, repo string) bool {
+		t.Helper()
+		on, err := db.StaleApprovalPolicyEnabled(database, channelID, owner, repo)
+		if err != nil {
+			t.Fatalf("StaleApprovalPolicyEnabled: %v", err)
+		}
+		return on
+	}
+
+	if got := run("stale-approvals on octo/app"); !strings.Contains(got, "will now reset") {
+		t.Errorf("opting a repo in replied %q", got)
+	}
+	if !enabled("C9", "octo", "app") {
+		t.Error("repo not opted in")
+	}
+
+	run("stale-approvals off octo/app")
+	if enabled("C9", "octo", "app") {
+		t.Error("repo still opted in")
+	}
+
+	run("stale-approvals on channel")
+	if !enabled("C1", "octo", "other") {
+		t.Error("channel not opted in")
+	}
+
+	for _, text := range []string{"stale-approvals maybe octo/app", "stale-approvals on octo", "stale-approvals on"} {
+		if got := run(text); strings.Contains(got, "will now reset") {
+			t.Errorf("%q was accepted: %q", text, got)
+		}
+	}
+}
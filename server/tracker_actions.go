@@ -0,0 +1,271 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+// Action IDs for the buttons on a tracker message. Each button's value is
+// the tracker ID.
+const (
+	actionTrackerReviewing = "tracker_reviewing"
+	actionTrackerSnooze    = "tracker_snooze"
+	actionTrackerAddPR     = "tracker_add_pr"
+	actionTrackerUntrack   = "tracker_untrack"
+)
+
+// trackerSnoozeDuration is how long "Snooze" suppresses reminders.
+const trackerSnoozeDuration = 24 * time.Hour
+
+// trackerActionBlock builds the row of buttons shown under a tracker.
+func trackerActionBlock(trackerID int64) *slack.ActionBlock {
+	value := strconv.FormatInt(trackerID, 10)
+
+	reviewingBtn := slack.NewButtonBlockElement(actionTrackerReviewing, value,
+		slack.NewTextBlockObject("plain_text", ":eyes: I'm reviewing", true, false)).
+		WithStyle(slack.StylePrimary)
+	snoozeBtn := slack.NewButtonBlockElement(actionTrackerSnooze, value,
+		slack.NewTextBlockObject("plain_text", ":zzz: Snooze", true, false))
+	addPRBtn := slack.NewButtonBlockElement(actionTrackerAddPR, value,
+		slack.NewTextBlockObject("plain_text", "+ Add PR", false, false))
+	untrackBtn := slack.NewButtonBlockElement(actionTrackerUntrack, value,
+		slack.NewTextBlockObject("plain_text", "Untrack", false, false)).
+		WithStyle(slack.StyleDanger).
+		WithConfirm(slack.NewConfirmationBlockObject(
+			slack.NewTextBlockObject("plain_text", "Untrack these PRs?", false, false),
+			slack.NewTextBlockObject("plain_text", "Revue will stop updating this tracker and sending reminders for it.", false, false),
+			slack.NewTextBlockObject("plain_text", "Untrack", false, false),
+			slack.NewTextBlockObject("plain_text", "Cancel", false, false),
+		))
+
+	return slack.NewActionBlock("tracker_actions", reviewingBtn, snoozeBtn, addPRBtn, untrackBtn)
+}
+
+// trackerIDFromAction reads the tracker ID stored in a button's value.
+func trackerIDFromAction(action *slack.BlockAction) (int64, error) {
+	trackerID, err := strconv.ParseInt(action.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tracker ID %q: %w", action.Value, err)
+	}
+	return trackerID, nil
+}
+
+// handleTrackerReviewing marks the clicking user as reviewing every PR in
+// the tracker that is still in review.
+func handleTrackerReviewing(payload slack.InteractionCallback, action *slack.BlockAction) {
+	trackerID, err := trackerIDFromAction(action)
+	if err != nil {
+		log.Printf("Failed to handle %s: %v", action.ActionID, err)
+		return
+	}
+
+	prs, err := db.GetPullRequestsByTracker(database, trackerID)
+	if err != nil {
+		log.Printf("Failed to get PRs for tracker %d: %v", trackerID, err)
+		return
+	}
+
+	for _, pr := range prs {
+		if pr.Status == "merged" || pr.Status == "closed" {
+			continue
+		}
+		// Keep claiming the rest; the refresh shows whichever claims stuck
+		if err := db.ClaimReview(database, pr.ID, payload.User.ID); err != nil {
+			log.Printf("Failed to claim review of PR %d: %v", pr.ID, err)
+		}
+	}
+
+	if err := enqueueTrackerRefresh(trackerID); err != nil {
+		log.Printf("Failed to queue tracker refresh: %v", err)
+	}
+}
+
+// handleTrackerSnooze suppresses reminders for the tracker for a day and
+// lets the clicking user know.
+func handleTrackerSnooze(payload slack.InteractionCallback, action *slack.BlockAction) {
+	trackerID, err := trackerIDFromAction(action)
+	if err != nil {
+		log.Printf("Failed to handle %s: %v", action.ActionID, err)
+		return
+	}
+
+	if err := db.SnoozeTracker(database, trackerID, time.Now().Add(trackerSnoozeDuration)); err != nil {
+		log.Printf("Failed to snooze tracker %d: %v", trackerID, err)
+		return
+	}
+
+	_, err = slackClient.PostEphemeral(payload.Channel.ID, payload.User.ID,
+		slack.MsgOptionText(":zzz: Reminders for this tracker are snoozed for 24 hours.", false))
+	if err != nil {
+		log.Printf("Failed to post snooze confirmation: %v", err)
+	}
+}
+
+// handleTrackerAddPR opens a modal for adding another PR to the tracker.
+func handleTrackerAddPR(payload slack.InteractionCallback, action *slack.BlockAction) {
+	trackerID, err := trackerIDFromAction(action)
+	if err != nil {
+		log.Printf("Failed to handle %s: %v", action.ActionID, err)
+		return
+	}
+
+	urlInput := slack.NewPlainTextInputBlockElement(
+		slack.NewTextBlockObject("plain_text", "https://github.com/owner/repo/pull/123", false, false),
+		"pr_url_0",
+	)
+	inputBlock := slack.NewInputBlock("pr_url_block_0",
+		slack.NewTextBlockObject("plain_text", "PR URL", false, false), nil, urlInput)
+
+	modal := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      "add_pr",
+		Title:           slack.NewTextBlockObject("plain_text", "Add PR", false, false),
+		Submit:          slack.NewTextBlockObject("plain_text", "Add", false, false),
+		Close:           slack.NewTextBlockObject("plain_text", "Cancel", false, false),
+		PrivateMetadata: strconv.FormatInt(trackerID, 10),
+		Blocks:          slack.Blocks{BlockSet: []slack.Block{inputBlock, prApprovalsInputBlock(0)}},
+	}
+
+	if _, err := slackClient.OpenView(payload.TriggerID, modal); err != nil {
+		log.Printf("Failed to open add PR modal: %v", err)
+	}
+}
+
+// handleTrackerUntrack stops tracking the tracker's PRs. Only the
+// tracker's creator and its reviewers may untrack it; anyone else is told
+// so privately. The message is refreshed so its buttons disappear.
+func handleTrackerUntrack(payload slack.InteractionCallback, action *slack.BlockAction) {
+	trackerID, err := trackerIDFromAction(action)
+	if err != nil {
+		log.Printf("Failed to handle %s: %v", action.ActionID, err)
+		return
+	}
+
+	allowed, err := canUntrack(trackerID, payload.User.ID)
+	if err != nil {
+		log.Printf("Failed to check who can untrack tracker %d: %v", trackerID, err)
+		return
+	}
+	if !allowed {
+		_, err := slackClient.PostEphemeral(payload.Channel.ID, payload.User.ID,
+			slack.MsgOptionText("Only the person who started this tracker or one of its reviewers can untrack it.", false))
+		if err != nil {
+			log.Printf("Failed to post untrack rejection: %v", err)
+		}
+		return
+	}
+
+	if err := db.UntrackTracker(database, trackerID); err != nil {
+		log.Printf("Failed to untrack tracker %d: %v", trackerID, err)
+		return
+	}
+	log.Printf("Tracker %d untracked by %s", trackerID, payload.User.ID)
+	forgetTrackerCIChecks(trackerID)
+
+	if err := enqueueTrackerRefresh(trackerID); err != nil {
+		log.Printf("Failed to queue tracker refresh: %v", err)
+	}
+}
+
+// canUntrack reports whether a Slack user created the tracker or reviews
+// any of its PRs.
+func canUntrack(trackerID int64, slackUserID string) (bool, error) {
+	tracker, err := db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		return false, err
+	}
+	if tracker.CreatedBySlackUserID == slackUserID {
+		return true, nil
+	}
+
+	reviewers, err := db.GetReviewersByTracker(database, trackerID)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(reviewers, slackUserID), nil
+}
+
+// handleAddPRSubmission processes the "Add PR" modal. The new PR gets the
+// same reviewers as the rest of the tracker.
+func handleAddPRSubmission(w http.ResponseWriter, payload slack.InteractionCallback) {
+	trackerID, err := strconv.ParseInt(payload.View.PrivateMetadata, 10, 64)
+	if err != nil {
+		log.Printf("Invalid tracker ID in add PR modal: %v", err)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	prs, fieldErrors := readPRURLFields(payload.View.State.Values)
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
+		return
+	}
+
+	approvals, fieldErrors := readApprovalsFields(payload.View.State.Values, len(prs))
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
+		return
+	}
+
+	metas, fieldErrors := validateTrackablePRs(prs)
+	if len(fieldErrors) > 0 {
+		respondViewErrors(w, fieldErrors)
+		return
+	}
+
+	reviewerIDs, err := db.GetReviewersByTracker(database, trackerID)
+	if err != nil {
+		log.Printf("Failed to get reviewers for tracker %d: %v", trackerID, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	for i, pr := range prs {
+		prID, err := db.CreatePullRequest(database, trackerID, pr.Owner, pr.Repo, pr.Number, pr.URL, approvals[i])
+		if err != nil {
+			log.Printf("Failed to create pull request: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if err := db.UpdatePullRequestMetadata(database, prID, metas[i]); err != nil {
+			log.Printf("Failed to store pull request metadata: %v", err)
+			http.Error(w, "Internal error", http.StatusInternalServerError)
+			return
+		}
+		if approvals[i].Source != "manual" {
+			if err := enqueueJob(jobApprovalRequirement, prID, "", ""); err != nil {
+				log.Printf("Failed to queue approval requirement lookup: %v", err)
+			}
+		}
+		// CI that finished before the PR was tracked never reaches us as
+		// a webhook we'd match, so read what's already reported
+		if err := enqueueJob(jobCIStatus, prID, "", commitPartitionKey(pr.Owner, pr.Repo, metas[i].HeadSHA)); err != nil {
+			log.Printf("Failed to queue CI status lookup: %v", err)
+		}
+		for _, reviewerID := range reviewerIDs {
+			if err := db.CreateReviewer(database, prID, reviewerID); err != nil {
+				log.Printf("Failed to create reviewer: %v", err)
+				http.Error(w, "Internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// A completed tracker has work to do again
+	if _, err := db.ReactivateTracker(database, trackerID); err != nil {
+		log.Printf("Failed to reactivate tracker %d: %v", trackerID, err)
+	}
+
+	if err := enqueueTrackerRefresh(trackerID); err != nil {
+		log.Printf("Failed to queue tracker refresh: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+func TestHandleTrackerUntrackAuthorization(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        string
+		wantUntracked bool
+	}{
+		{"creator", "U123", true},
+		{"reviewer", "U1", true},
+		{"someone else", "U2", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testDB := newTestDB(t)
+			fake := newFakeSlack(t, "none")
+
+			prID := trackTestPR(t, "octo", "app", 1)
+			if err := db.CreateReviewer(database, prID, "U1"); err != nil {
+				t.Fatalf("CreateReviewer: %v", err)
+			}
+			pr, err := db.GetPullRequestByID(database, prID)
+			if err != nil {
+				t.Fatalf("GetPullRequestByID: %v", err)
+			}
+
+			payload := slack.InteractionCallback{User: slack.User{ID: tt.userID}}
+			payload.Channel.ID = "C123"
+			handleTrackerUntrack(payload, &slack.BlockAction{
+				ActionID: actionTrackerUntrack,
+				Value:    strconv.FormatInt(pr.TrackerID, 10),
+			})
+
+			tracker, err := db.GetTrackerByID(database, pr.TrackerID)
+			if err != nil {
+				t.Fatalf("GetTrackerByID: %v", err)
+			}
+			if untracked := tracker.Status == "untracked"; untracked != tt.wantUntracked {
+				t.Errorf("tracker status = %q, want untracked = %v", tracker.Status, tt.wantUntracked)
+			}
+
+			var refreshes int
+			if err := testDB.QueryRow("SELECT COUNT(*) FROM jobs WHERE kind = ?", jobTrackerRefresh).Scan(&refreshes); err != nil {
+				t.Fatalf("count jobs: %v", err)
+			}
+			if tt.wantUntracked && refreshes != 1 {
+				t.Errorf("queued %d tracker refreshes, want 1", refreshes)
+			}
+
+			// Only a rejected user is told anything
+			if rejected := len(fake.posts()) > 0; rejected == tt.wantUntracked {
+				t.Errorf("posted %d ephemeral messages, want rejection = %v", len(fake.posts()), !tt.wantUntracked)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+// trackerView is everything needed to render a tracker message. It's
+// loaded from the DB by loadTrackerView and rendered by renderTrackerBlocks,
+// which keeps the rendering itself free of I/O.
+type trackerView struct {
+	Tracker db.Tracker
+	PRs     []prView
+	Now     time.Time // reference time for PR ages
+}
+
+// prView is a single PR line in a tracker message.
+type prView struct {
+	PR        db.PullRequest
+	CIStatus  string // rolled-up CI state, empty if no checks reported
+	Reviewers []db.ReviewerStatus
+}
+
+// loadTrackerView gathers a tracker, its PRs and their reviewers from the
+// DB.
+func loadTrackerView(trackerID int64) (*trackerView, error) {
+	tracker, err := db.GetTrackerByID(database, trackerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tracker: %w", err)
+	}
+
+	prs, err := db.GetPullRequestsByTracker(database, trackerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get PRs: %w", err)
+	}
+
+	view := &trackerView{
+		Tracker: *tracker,
+		Now:     time.Now(),
+	}
+
+	for _, pr := range prs {
+		ciStatus, err := db.GetCIStatus(database, pr.GithubOwner, pr.GithubRepo, pr.HeadSHA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get CI status: %w", err)
+		}
+
+		reviewers, err := db.GetReviewerStatuses(database, pr.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reviewers: %w", err)
+		}
+
+		view.PRs = append(view.PRs, prView{PR: pr, CIStatus: ciStatus, Reviewers: reviewers})
+	}
+
+	return view, nil
+}
+
+// renderTrackerBlocks lays out a tracker message: a title, one section per
+// PR with its status and context lines of details and reviewers, and the
+// action buttons while the tracker is active. It's used for both the
+// initial post and every update.
+func renderTrackerBlocks(view trackerView) []slack.Block {
+	var blocks []slack.Block
+
+	blocks = append(blocks,
+		slack.NewSectionBlock(slack.NewTextBlockObject("mrkdwn", trackerTitle(view.Tracker), false, false), nil, nil, slack.SectionBlockOptionBlockID("tracker_title")),
+		slack.NewDividerBlock(),
+	)
+
+	for _, pv := range view.PRs {
+		pr := pv.PR
+		blockID := fmt.Sprintf("pr_%d", pr.ID)
+
+		line := fmt.Sprintf("<%s|%s/%s#%d> — %s %s",
+			pr.GithubPRURL, pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+			statusEmoji(displayStatus(pr)), statusLabel(displayStatus(pr)))
+		if pr.Title != "" {
+			line = fmt.Sprintf("*<%s|%s>*\n%s/%s#%d — %s %s",
+				pr.GithubPRURL, escapeMrkdwn(pr.Title), pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber,
+				statusEmoji(displayStatus(pr)), statusLabel(displayStatus(pr)))
+		}
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject("mrkdwn", line, false, false), nil, nil,
+			slack.SectionBlockOptionBlockID(blockID)))
+
+		var details []slack.MixedElement
+		for _, detail := range prDetails(pv, view.Now) {
+			details = append(details, slack.NewTextBlockObject("mrkdwn", detail, false, false))
+		}
+		blocks = append(blocks, slack.NewContextBlock(blockID+"_details", details...))
+
+		if len(pv.Reviewers) > 0 {
+			blocks = append(blocks, slack.NewContextBlock(blockID+"_reviewers",
+				slack.NewTextBlockObject("mrkdwn", reviewersLine(pv), false, false)))
+		}
+	}
+
+	if view.Tracker.Status == "active" {
+		blocks = append(blocks, slack.NewDividerBlock())
+		blocks = append(blocks, trackerActionBlock(view.Tracker.ID))
+	}
+
+	return blocks
+}
+
+// escapeMrkdwn escapes the characters Slack treats as control sequences
+// in mrkdwn, for text that comes from GitHub such as PR titles.
+func escapeMrkdwn(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// renderTrackerText is the plain-text fallback Slack shows in
+// notifications and clients that can't render blocks.
+func renderTrackerText(view trackerView) string {
+	lines := []string{trackerTitle(view.Tracker)}
+	for _, pv := range view.PRs {
+		pr := pv.PR
+		lines = append(lines, fmt.Sprintf("• %s/%s#%d — %s",
+			pr.GithubOwner, pr.GithubRepo, pr.GithubPRNumber, statusLabel(displayStatus(pr))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trackerTitle returns the heading for a tracker in its current status.
+func trackerTitle(tracker db.Tracker) string {
+	switch tracker.Status {
+	case "completed":
+		return "*PR Tracker* — :tada: All done!"
+	case "untracked":
+		return "*PR Tracker* — no longer tracked"
+	default:
+		return "*PR Tracker*"
+	}
+}
+
+// prDetails returns the context items shown under a PR: author, base
+// branch and size when known, approvals (and whether GitHub also requires
+// a code owner's, and if it's still missing) while it's in review, CI, how
+// long it's been tracked, and a warning when new commits reset its
+// approvals.
+func prDetails(pv prView, now time.Time) []string {
+	pr := pv.PR
+
+	var details []string
+	if pr.AuthorLogin != "" {
+		details = append(details, "by "+escapeMrkdwn(pr.AuthorLogin))
+	}
+	if pr.BaseBranch != "" {
+		details = append(details, "into `"+escapeMrkdwn(pr.BaseBranch)+"`")
+	}
+	if pr.Additions > 0 || pr.Deletions > 0 {
+		details = append(details, fmt.Sprintf("+%d −%d", pr.Additions, pr.Deletions))
+	}
+	if pr.Status == "open" || pr.Status == "approved" || pr.Status == "changes_requested" {
+		approvals := fmt.Sprintf("%d/%d approvals", pr.ApprovalsCurrent, pr.ApprovalsRequired)
+		if pr.CodeOwnerReviewRequired {
+			// Enough approvals but still open means no code owner has approved
+			if pr.Status == "open" && pr.ApprovalsCurrent >= pr.ApprovalsRequired {
+				approvals += " (code owner needed)"
+			} else {
+				approvals += " (code owner required)"
+			}
+		}
+		details = append(details, approvals)
+	}
+	if ci := ciIndicator(pv.CIStatus); ci != "" {
+		details = append(details, "CI "+ci)
+	}
+	details = append(details, "tracked "+formatAge(now.Sub(pr.CreatedAt))+" ago")
+	if pr.NewCommitsSinceApproval {
+		details = append(details, ":arrows_counterclockwise: new commits since approval")
+	}
+	return details
+}
+
+// reviewersLine shows each of a PR's reviewers with where they are with
+// their review. Reviewers are @-mentioned, which Slack renders with their
+// current name; updating the message doesn't notify them again.
+func reviewersLine(pv prView) string {
+	var entries []string
+	for _, rv := range pv.Reviewers {
+		who := fmt.Sprintf("<@%s>", rv.SlackUserID)
+
+		var entry string
+		switch {
+		case rv.State == "approved":
+			entry = ":white_check_mark: " + who + " approved"
+		case rv.State == "changes_requested":
+			entry = ":no_entry: " + who + " requested changes"
+		case rv.State == "commented":
+			entry = ":speech_balloon: " + who + " commented"
+		case rv.Claimed:
+			entry = ":eyes: " + who + " reviewing"
+		default:
+			entry = ":hourglass_flowing_sand: " + who + " not reviewed yet"
+		}
+		entries = append(entries, entry)
+	}
+	return "Reviewers: " + strings.Join(entries, "  ·  ")
+}
+
+// postTrackerMessage sends a newly created tracker to its Slack channel
+// and returns the message timestamp (used to update the message later).
+func postTrackerMessage(trackerID int64) (string, error) {
+	view, err := loadTrackerView(trackerID)
+	if err != nil {
+		return "", err
+	}
+
+	_, ts, err := slackClient.PostMessage(
+		view.Tracker.SlackChannelID,
+		slack.MsgOptionText(renderTrackerText(*view), false),
+		slack.MsgOptionBlocks(renderTrackerBlocks(*view)...),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to post message: %w", err)
+	}
+
+	return ts, nil
+}
+
+// updateTrackerMessage fetches the current state of a tracker from the DB
+// and updates the Slack message with the latest PR statuses. Trackers
+// whose message was never posted are skipped.
+func updateTrackerMessage(trackerID int64) error {
+	view, err := loadTrackerView(trackerID)
+	if err != nil {
+		return err
+	}
+	if view.Tracker.SlackMessageTS == "" {
+		return nil
+	}
+
+	_, _, _, err = slackClient.UpdateMessage(
+		view.Tracker.SlackChannelID,
+		view.Tracker.SlackMessageTS,
+		slack.MsgOptionText(renderTrackerText(*view), false),
+		slack.MsgOptionBlocks(renderTrackerBlocks(*view)...),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update message: %w", err)
+	}
+
+	return nil
+}
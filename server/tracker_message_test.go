@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dylfrancis/revue/db"
+	"github.com/slack-go/slack"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// testNow is the fixed reference time tracker views are rendered at.
+var testNow = time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC)
+
+// testPR returns an open PR tracked two days before testNow, with its
+// metadata fetched.
+func testPR(id int64, number int) db.PullRequest {
+	return db.PullRequest{
+		ID:                      id,
+		TrackerID:               1,
+		GithubOwner:             "octo",
+		GithubRepo:              "app",
+		GithubPRNumber:          number,
+		GithubPRURL:             fmt.Sprintf("https://github.com/octo/app/pull/%d", number),
+		Status:                  "open",
+		ApprovalsRequired:       2,
+		HeadSHA:                 "abc123",
+		CreatedAt:               testNow.Add(-50 * time.Hour),
+		Title:                   "Add <retry> & backoff",
+		AuthorLogin:             "carol",
+		BaseBranch:              "main",
+		Additions:               120,
+		Deletions:               8,
+		ApprovalsRequiredSource: "github",
+	}
+}
+
+// TestRenderTrackerBlocks checks the tracker-specific blocks against golden
+// files. The action buttons are the same for every active tracker, so they're
+// only checked for presence here and have a golden of their own below.
+func TestRenderTrackerBlocks(t *testing.T) {
+	active := db.Tracker{ID: 1, SlackChannelID: "C123", SlackMessageTS: "1700000000.000100", Status: "active", CreatedBySlackUserID: "U0CREATOR"}
+	completed := active
+	completed.Status = "completed"
+	completed.CompletedAt = sql.NullTime{Time: testNow, Valid: true}
+	untracked := active
+	untracked.Status = "untracked"
+
+	approving := testPR(10, 1)
+	approving.ApprovalsCurrent = 1
+	approving.CodeOwnerReviewRequired = true
+
+	changesRequested := testPR(11, 2)
+	changesRequested.Status = "changes_requested"
+	changesRequested.Title = ""
+	changesRequested.NewCommitsSinceApproval = true
+
+	draft := testPR(12, 3)
+	draft.IsDraft = true
+
+	merged := testPR(13, 4)
+	merged.Status = "merged"
+	merged.ApprovalsCurrent = 2
+
+	closed := testPR(14, 5)
+	closed.Status = "closed"
+
+	codeOwnerNeeded := testPR(15, 6)
+	codeOwnerNeeded.ApprovalsCurrent = 2
+	codeOwnerNeeded.CodeOwnerReviewRequired = true
+
+	tests := []struct {
+		name string
+		view trackerView
+	}{
+		{
+			name: "active_mixed_reviewers",
+			view: trackerView{Tracker: active, Now: testNow, PRs: []prView{
+				{PR: approving, CIStatus: "success", Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1", State: "approved"},
+					{SlackUserID: "U2", State: "commented"},
+					{SlackUserID: "U3", Claimed: true},
+					{SlackUserID: "U4"},
+				}},
+				{PR: changesRequested, CIStatus: "pending", Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1", State: "changes_requested"},
+				}},
+			}},
+		},
+		{
+			name: "ci_failing",
+			view: trackerView{Tracker: active, Now: testNow, PRs: []prView{
+				{PR: testPR(10, 1), CIStatus: "failure", Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1"},
+				}},
+			}},
+		},
+		{
+			name: "draft",
+			view: trackerView{Tracker: active, Now: testNow, PRs: []prView{
+				{PR: draft, Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1"},
+				}},
+			}},
+		},
+		{
+			name: "code_owner_needed",
+			view: trackerView{Tracker: active, Now: testNow, PRs: []prView{
+				{PR: codeOwnerNeeded, CIStatus: "success", Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1", State: "approved"},
+					{SlackUserID: "U2", State: "approved"},
+					{SlackUserID: "U3"},
+				}},
+			}},
+		},
+		{
+			name: "completed",
+			view: trackerView{Tracker: completed, Now: testNow, PRs: []prView{
+				{PR: merged, CIStatus: "success", Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1", State: "approved"},
+					{SlackUserID: "U2", State: "approved"},
+				}},
+				{PR: closed},
+			}},
+		},
+		{
+			name: "untracked",
+			view: trackerView{Tracker: untracked, Now: testNow, PRs: []prView{
+				{PR: testPR(10, 1), Reviewers: []db.ReviewerStatus{
+					{SlackUserID: "U1"},
+				}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocks := renderTrackerBlocks(tt.view)
+
+			last := blocks[len(blocks)-1]
+			hasActions := last.BlockType() == slack.MBTAction && last.ID() == "tracker_actions"
+			if wantActions := tt.view.Tracker.Status == "active"; hasActions != wantActions {
+				t.Fatalf("action buttons shown = %v, want %v", hasActions, wantActions)
+			}
+			if hasActions {
+				blocks = blocks[:len(blocks)-1]
+			}
+
+			checkGolden(t, "tracker_"+tt.name, blocks)
+		})
+	}
+}
+
+func TestTrackerActionBlock(t *testing.T) {
+	checkGolden(t, "tracker_actions", trackerActionBlock(1))
+}
+
+// checkGolden compares v, encoded as indented JSON, with testdata/<name>.golden.
+// slack-go's own MarshalJSON methods escape <, > and & in nested blocks, so
+// the JSON is decoded and re-encoded unescaped to keep the golden files
+// readable as the mrkdwn Slack receives.
+func checkGolden(t *testing.T, name string, v any) {
+	t.Helper()
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var decoded any
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(decoded); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	got := buf.Bytes()
+
+	golden := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatalf("create testdata: %v", err)
+		}
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("write golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read golden file (run with -update to create it): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("output differs from %s (run with -update to accept):\n%s", golden, got)
+	}
+}